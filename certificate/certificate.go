@@ -0,0 +1,130 @@
+package certificate
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/grade"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Certificate of analysis of a sample, or of the final sample of a heat.
+type Certificate struct {
+	Company string
+	Footer  string
+	Heat    string // empty for a single sample certificate
+	Sample  *sample.Record
+	Limits  map[string]config.ElementLimits // of the sample's grade
+	Issued  time.Time
+
+	Rows []Row
+}
+
+// Row of the chemistry table.
+type Row struct {
+	Element string
+	Value   string
+	Min     string
+	Max     string
+	Status  string
+}
+
+// New certificate for a sample, with a row for each of elements in order, followed by
+// any other elements in the grade limits.
+func New(conf *config.Config, s *sample.Record, heat string, limits map[string]config.ElementLimits) *Certificate {
+	c := &Certificate{
+		Company: conf.Certificate.Company,
+		Footer:  conf.Certificate.Footer,
+		Heat:    heat,
+		Sample:  s,
+		Limits:  limits,
+		Issued:  time.Now(),
+	}
+
+	elements := append([]string(nil), conf.ElementsToDisplay...)
+	for _, el := range sortedKeys(limits) {
+		if _, ok := conf.ElementOrder[el]; !ok {
+			elements = append(elements, el)
+		}
+	}
+
+	for _, el := range elements {
+		v, measured := s.ResultsMap[el]
+		l, limited := limits[el]
+		if !measured && !limited {
+			continue
+		}
+
+		row := Row{Element: el, Value: "-", Min: formatLimit(l.Min), Max: formatLimit(l.Max)}
+		if measured {
+			row.Value = strconv.FormatFloat(v, 'f', 3, 64)
+			if limited {
+				row.Status = statusText(grade.Status(l, v))
+			}
+		}
+		c.Rows = append(c.Rows, row)
+	}
+
+	return c
+}
+
+// Title of the certificate's subject.
+func (c *Certificate) Title() string {
+	if c.Heat != "" {
+		return "Heat " + c.Heat
+	}
+	return "Sample " + c.Sample.SampleName
+}
+
+// Fields of the sample shown above the chemistry table.
+func (c *Certificate) Fields() [][2]string {
+	f := make([][2]string, 0, 8)
+	if c.Heat != "" {
+		f = append(f, [2]string{"Heat", c.Heat})
+	}
+	f = append(f,
+		[2]string{"Sample", c.Sample.SampleName},
+		[2]string{"Furnace", c.Sample.Furnace},
+		[2]string{"Sampled", c.Sample.TimeStamp.Format("2006-01-02 15:04:05")},
+		[2]string{"Spectrometer", strconv.Itoa(c.Sample.Spectro)},
+		[2]string{"Method", orDash(c.Sample.Method)},
+		[2]string{"Operator", orDash(c.Sample.Operator)},
+		[2]string{"Grade", orDash(c.Sample.Grade)},
+	)
+	return f
+}
+
+func sortedKeys(limits map[string]config.ElementLimits) []string {
+	keys := make([]string, 0, len(limits))
+	for k := range limits {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLimit(l *float64) string {
+	if l == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*l, 'f', -1, 64)
+}
+
+func statusText(status string) string {
+	switch status {
+	case sample.StatusOK, sample.StatusWarning:
+		return "In spec"
+	case sample.StatusOutOfSpec:
+		return "Out of spec"
+	}
+	return ""
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package certificate
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func f(v float64) *float64 { return &v }
+
+func testCertificate() *Certificate {
+	conf := &config.Config{
+		ElementsToDisplay: []string{"C", "Si", "Mn"},
+		ElementOrder:      map[string]int{"C": 0, "Si": 1, "Mn": 2},
+	}
+	conf.Certificate.Company = "Foundry (Pty) Ltd"
+
+	s := &sample.Record{
+		SampleName: "H123-T", Furnace: "F1", Spectro: 3, Grade: "GG25", Method: "Fe-10", Operator: "Jürgen",
+		TimeStamp:  time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local),
+		ResultsMap: map[string]float64{"C": 3.3, "Si": 2.5, "Mg": 0.001},
+	}
+	limits := map[string]config.ElementLimits{"C": {Min: f(3.2), Max: f(3.5)}, "Si": {Max: f(2.4)}, "Cr": {Max: f(0.2)}}
+
+	return New(conf, s, "H123", limits)
+}
+
+func TestRows(t *testing.T) {
+	c := testCertificate()
+
+	want := []Row{
+		{Element: "C", Value: "3.300", Min: "3.2", Max: "3.5", Status: "In spec"},
+		{Element: "Si", Value: "2.500", Max: "2.4", Status: "Out of spec"},
+		{Element: "Cr", Value: "-", Max: "0.2"},
+	}
+	if len(c.Rows) != len(want) {
+		t.Fatalf("got rows %+v, want %+v", c.Rows, want)
+	}
+	for i := range want {
+		if c.Rows[i] != want[i] {
+			t.Errorf("row %d: got %+v, want %+v", i, c.Rows[i], want[i])
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	c := testCertificate()
+
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Foundry (Pty) Ltd", "<td>H123-T</td>", "<td>Jürgen</td>", `class="out">Out of spec`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("html does not contain %q", want)
+		}
+	}
+
+	buf.Reset()
+	if err := WritePDF(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.Bytes()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("invalid pdf header or trailer")
+	}
+	if !bytes.Contains(pdf, []byte(`(Foundry \(Pty\) Ltd) Tj`)) || !bytes.Contains(pdf, []byte(`(J\374rgen) Tj`)) {
+		t.Fatal("pdf text not escaped")
+	}
+
+	// check xref offsets point at objects
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := strings.Split(string(pdf[xref:]), "\n")[3:9]
+	for i, e := range entries {
+		off, _ := strconv.Atoi(e[:10])
+		if want := strconv.Itoa(i+1) + " 0 obj"; !bytes.HasPrefix(pdf[off:], []byte(want)) {
+			t.Fatalf("xref entry %d does not point at %q", i+1, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package certificate
+
+import (
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Certificate of Analysis - {{.Title}}</title>
+    <style>
+        body { font-family: Helvetica, Arial, sans-serif; margin: 2em; }
+        table { border-collapse: collapse; margin-top: 1em; }
+        th, td { border: 1px solid #444; padding: 0.3em 0.8em; text-align: left; }
+        .fields td, .fields th { border: none; padding: 0.1em 0.8em 0.1em 0; }
+        .out { color: #c00; font-weight: bold; }
+        footer { margin-top: 2em; font-size: 0.9em; }
+        @media print { body { margin: 0; } }
+    </style>
+</head>
+<body>
+{{if .Company}}<h2>{{.Company}}</h2>{{end}}
+<h1>Certificate of Analysis</h1>
+<table class="fields">
+{{range .Fields}}    <tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+<table>
+    <tr><th>Element</th><th>Result (%)</th><th>Min</th><th>Max</th><th>Status</th></tr>
+{{range .Rows}}    <tr><td>{{.Element}}</td><td>{{.Value}}</td><td>{{.Min}}</td><td>{{.Max}}</td><td{{if eq .Status "Out of spec"}} class="out"{{end}}>{{.Status}}</td></tr>
+{{end}}</table>
+<footer>
+    <p>Issued {{.Issued.Format "2006-01-02 15:04"}}</p>
+    {{if .Footer}}<p>{{.Footer}}</p>{{end}}
+</footer>
+</body>
+</html>
+`))
+
+// WriteHTML writes a printable html page of the certificate.
+func WriteHTML(w io.Writer, c *Certificate) error {
+	return htmlTemplate.Execute(w, c)
+}
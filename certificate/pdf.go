@@ -0,0 +1,134 @@
+package certificate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A4 page in points
+const (
+	pageWidth  = 595.28
+	pageHeight = 841.89
+	margin     = 56.0
+)
+
+// table column x positions
+var columnX = []float64{margin, margin + 90, margin + 200, margin + 280, margin + 360}
+
+// WritePDF writes the certificate as a single page PDF document, using the standard Helvetica fonts.
+func WritePDF(w io.Writer, c *Certificate) error {
+	var content pdfContent
+	y := pageHeight - margin
+
+	if c.Company != "" {
+		content.text("F2", 14, margin, y, c.Company)
+		y -= 28
+	}
+	content.text("F2", 20, margin, y, "Certificate of Analysis")
+	y -= 34
+
+	for _, f := range c.Fields() {
+		content.text("F2", 11, margin, y, f[0])
+		content.text("F1", 11, margin+110, y, f[1])
+		y -= 16
+	}
+	y -= 14
+
+	content.text("F2", 11, columnX[0], y, "Element")
+	content.text("F2", 11, columnX[1], y, "Result (%)")
+	content.text("F2", 11, columnX[2], y, "Min")
+	content.text("F2", 11, columnX[3], y, "Max")
+	content.text("F2", 11, columnX[4], y, "Status")
+	content.line(margin, y-5, pageWidth-margin, y-5)
+	y -= 20
+
+	for _, r := range c.Rows {
+		if y < margin+60 {
+			break // single page
+		}
+		font := "F1"
+		if r.Status == "Out of spec" {
+			font = "F2"
+		}
+		content.text("F1", 11, columnX[0], y, r.Element)
+		content.text("F1", 11, columnX[1], y, r.Value)
+		content.text("F1", 11, columnX[2], y, r.Min)
+		content.text("F1", 11, columnX[3], y, r.Max)
+		content.text(font, 11, columnX[4], y, r.Status)
+		y -= 16
+	}
+	content.line(margin, y+11, pageWidth-margin, y+11)
+
+	y = margin + 20
+	content.text("F1", 9, margin, y, "Issued "+c.Issued.Format("2006-01-02 15:04"))
+	if c.Footer != "" {
+		content.text("F1", 9, margin, y-12, c.Footer)
+	}
+
+	stream := content.String()
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pageWidth, pageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Length " + strconv.Itoa(len(stream)) + " >>\nstream\n" + stream + "\nendstream",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// page content stream
+type pdfContent struct {
+	strings.Builder
+}
+
+func (pc *pdfContent) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(pc, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+func (pc *pdfContent) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(pc, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// pdfString escapes text for a PDF literal string in WinAnsi encoding.
+// Characters outside of Latin-1 are replaced.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r < 128:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
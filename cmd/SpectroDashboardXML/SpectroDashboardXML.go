@@ -3,8 +3,8 @@ package main
 import (
 	"flag"
 
+	"github.com/RoanBrand/SpectroDashboard/dashboard"
 	"github.com/RoanBrand/SpectroDashboard/log"
-	"github.com/RoanBrand/SpectroDashboard/xml_spectro"
 	"github.com/kardianos/service"
 )
 
@@ -12,13 +12,20 @@ func main() {
 	svcFlag := flag.String("service", "", "Control the system service.")
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		if err := dashboard.Command(flag.Arg(0), flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	svcConfig := &service.Config{
 		Name:        "SpectroDashboardXML",
 		DisplayName: "Spectrometer Dashboard App",
 		Description: "Provides API for latest XML spectrometer results",
 	}
 
-	s, err := service.New(xml_spectro.NewApp(), svcConfig)
+	s, err := service.New(dashboard.NewApp(), svcConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
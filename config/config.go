@@ -3,7 +3,13 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
 )
 
 type Config struct {
@@ -16,18 +22,168 @@ type Config struct {
 	DataSource           string `json:"data_source"`            // If xml: folder of xml files. If mdb: path to mdb file database.
 	DebugMode            bool   `json:"debug_mode"`             // print logs out to console instead of file when true
 	RemoteMachineAddress string `json:"remote_machine_address"` // optional: mix results with remote spectro (like spectro 3 xml)
+	RemoteSpectroNumber  int    `json:"remote_spectro_number"`  // spectro of remote_machine_address. Default 3.
+	HistoryFile          string `json:"history_file"`           // sample history database. Defaults to history.db next to executable.
+	OutboxFile           string `json:"outbox_file"`            // samples waiting to be inserted into Shopware. Defaults to outbox.db next to executable.
+
+	// Spectro machines to get results from. If empty, it is made up from
+	// spectro_number, data_source and remote_machine_address.
+	Sources []Source `json:"sources"`
+
+	// Alloy grade specifications: grade name -> element -> limits.
+	Grades map[string]map[string]ElementLimits `json:"grades"`
+	// Which grade a sample is checked against. First matching assignment applies.
+	GradeAssignments []GradeAssignment `json:"grade_assignments"`
+
+	// Type of samples, e.g. tap or bath, by sample name. First matching rule applies.
+	// Defaults to sample names ending in T being tap samples.
+	SampleTypes []SampleTypeRule `json:"sample_types"`
+	// Fields encoded in sample names. First matching format applies.
+	SampleNameFormats []SampleNameFormat `json:"sample_name_formats"`
+
+	Furnaces          map[string]Furnace `json:"furnaces"`           // by furnace name
+	AdditionMaterials []AdditionMaterial `json:"addition_materials"` // for furnace corrections
+
+	Export struct {
+		CSVDelimiter        string `json:"csv_delimiter"`         // default ","
+		CSVDecimalSeparator string `json:"csv_decimal_separator"` // default "."
+	} `json:"export"`
+
+	Health struct {
+		MaxSampleAge int `json:"max_sample_age"` // minutes. Newest sample older than this is reported as a warning. 0 to disable.
+	} `json:"health"`
+
+	Heats struct {
+		SampleGap int `json:"sample_gap"` // minutes. Longer between samples without heat numbers starts a new heat. Default 60.
+	} `json:"heats"`
+
+	Certificate struct {
+		Company string `json:"company"` // heading of certificates of analysis
+		Footer  string `json:"footer"`
+	} `json:"certificate"`
 
 	ShopwareDB struct {
 		Address  string `json:"address"`
 		User     string `json:"user"`
 		Password string `json:"password"`
 		Database string `json:"database"`
-		Table    string `json:"table"`
+		Table    string `json:"table"` // may include schema, e.g. "dbo.AccSpectrograph"
+
+		// Columns of table samples are written to. Default to the original Shopware layout.
+		Columns ShopwareColumns `json:"columns"`
 	} `json:"remote_database"`
 
 	ElementOrder map[string]int // internal use and just for displays
 }
 
+// ShopwareColumns maps sample fields to columns of the Shopware table.
+type ShopwareColumns struct {
+	TimeStamp  string            `json:"time_stamp"`  // default "DateTimeStamp"
+	SampleName string            `json:"sample_name"` // default "SampleName"
+	Furnace    string            `json:"furnace"`     // default "Furname"
+	Spectro    string            `json:"spectro"`     // default "Spectro"
+	Elements   map[string]string `json:"elements"`    // element -> column. Defaults to columns named after 25 common elements.
+
+	// Optional columns of fields parsed from sample names. Not written if empty.
+	Heat     string `json:"heat"`
+	Sequence string `json:"sequence"`
+	Type     string `json:"type"`
+	Shift    string `json:"shift"`
+}
+
+var defaultShopwareElements = []string{"C", "Si", "Mn", "P", "S", "Cu", "Cr", "Al", "Ti", "Sn", "Zn", "Pb",
+	"Ni", "Mo", "Co", "Nb", "V", "W", "Mg", "Bi", "Ca", "As", "Sb", "Te", "Fe"}
+
+// Source types.
+const (
+	SourceMDB    = "mdb"    // Access database file of spectro machine
+	SourceXML    = "xml"    // folder of spectro result xml files
+	SourceRemote = "remote" // another SpectroDashboard service
+)
+
+type Source struct {
+	Type          string `json:"type"` // "mdb", "xml" or "remote"
+	SpectroNumber int    `json:"spectro_number"`
+	DataSource    string `json:"data_source"` // If mdb: db connection string. If xml: folder of xml files. If remote: machine address.
+	Timeout       int    `json:"timeout"`     // remote only: seconds to wait for a response. Default 10.
+	Enabled       *bool  `json:"enabled"`     // default true
+}
+
+const defaultRemoteTimeout = 10
+
+// TimeoutDuration of requests to a remote source.
+func (s *Source) TimeoutDuration() time.Duration {
+	if s.Timeout <= 0 {
+		return time.Second * defaultRemoteTimeout
+	}
+	return time.Second * time.Duration(s.Timeout)
+}
+
+// Local is true for sources read directly from a spectro machine, as opposed to through a remote service.
+func (s *Source) Local() bool {
+	return s.Type != SourceRemote
+}
+
+// ElementLimits of an element in a grade. Values between min and max, but outside of
+// warn_min and warn_max, are in spec with a warning. Limits that are not set are not checked.
+type ElementLimits struct {
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+	WarnMin *float64 `json:"warn_min"`
+	WarnMax *float64 `json:"warn_max"`
+	Aim     *float64 `json:"aim"` // target for corrections. Defaults to middle of the limits.
+}
+
+// GradeAssignment assigns a grade to samples of a furnace and/or with sample names
+// matching a regular expression.
+type GradeAssignment struct {
+	Furnace    string `json:"furnace"`
+	SampleName string `json:"sample_name"`
+	Grade      string `json:"grade"`
+}
+
+// SampleTypeRule assigns a sample type to samples with names matching a regular expression.
+type SampleTypeRule struct {
+	Type       string `json:"type"`        // "tap", "bath", "ladle" or "check"
+	SampleName string `json:"sample_name"` // empty matches all
+}
+
+// SampleNameFormat is a regular expression on sample names, with named groups of the fields
+// encoded in them: furnace, heat, sequence, type and shift. Groups are optional.
+type SampleNameFormat struct {
+	Pattern string            `json:"pattern"`
+	Types   map[string]string `json:"types"` // value of type group -> sample type, e.g. "T": "tap". Default is the value itself.
+}
+
+// Named groups of a SampleNameFormat.
+var SampleNameFields = []string{"furnace", "heat", "sequence", "type", "shift"}
+
+var defaultSampleTypes = []SampleTypeRule{{Type: sample.TypeTap, SampleName: "(?i)T$"}}
+
+type Furnace struct {
+	BathWeight float64 `json:"bath_weight"` // kg
+}
+
+// AdditionMaterial is added to a furnace to correct its chemistry.
+type AdditionMaterial struct {
+	Name        string             `json:"name"`
+	Composition map[string]float64 `json:"composition"` // element -> %
+	Recovery    map[string]float64 `json:"recovery"`    // element -> fraction that ends up in the bath. Defaults to 1.
+}
+
+// Furnace returns the config of a furnace by name, case insensitive.
+func (c *Config) Furnace(name string) (Furnace, bool) {
+	if f, ok := c.Furnaces[name]; ok {
+		return f, true
+	}
+	for n, f := range c.Furnaces {
+		if strings.EqualFold(n, name) {
+			return f, true
+		}
+	}
+	return Furnace{}, false
+}
+
 func LoadConfig(filePath string) (*Config, error) {
 	conf := Config{
 		HTTPServerPort:        "80",
@@ -41,6 +197,7 @@ func LoadConfig(filePath string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
 	dec := json.NewDecoder(f)
 	err = dec.Decode(&conf)
@@ -54,12 +211,161 @@ func LoadConfig(filePath string) (*Config, error) {
 	}
 
 	// validation
+	if len(conf.Sources) == 0 {
+		if conf.SpectroNumber == 0 {
+			return nil, errors.New("no spectro_number in config file")
+		}
+		if conf.DataSource == "" {
+			return nil, errors.New("no data_source provided in config file")
+		}
+		conf.Sources = legacySources(&conf)
+	}
+
+	enabled := conf.Sources[:0]
+	for i := range conf.Sources {
+		s := &conf.Sources[i]
+		if s.Enabled != nil && !*s.Enabled {
+			continue
+		}
+
+		switch s.Type {
+		case SourceMDB, SourceXML, SourceRemote:
+		default:
+			return nil, fmt.Errorf("source %d: unknown type %q in config file", i+1, s.Type)
+		}
+		if s.SpectroNumber == 0 {
+			return nil, fmt.Errorf("source %d: no spectro_number in config file", i+1)
+		}
+		if s.DataSource == "" {
+			return nil, fmt.Errorf("source %d: no data_source provided in config file", i+1)
+		}
+		enabled = append(enabled, *s)
+	}
+
+	if len(enabled) == 0 {
+		return nil, errors.New("no enabled sources in config file")
+	}
+	conf.Sources = enabled
+
 	if conf.SpectroNumber == 0 {
-		return nil, errors.New("no spectro_number in config file")
+		conf.SpectroNumber = conf.Sources[0].SpectroNumber
 	}
-	if conf.DataSource == "" {
-		return nil, errors.New("no data_source provided in config file")
+
+	for i, am := range conf.AdditionMaterials {
+		if am.Name == "" {
+			return nil, fmt.Errorf("addition material %d: no name in config file", i+1)
+		}
+		if len(am.Composition) == 0 {
+			return nil, fmt.Errorf("addition material %q: no composition in config file", am.Name)
+		}
+	}
+
+	for i, ga := range conf.GradeAssignments {
+		if _, ok := conf.Grades[ga.Grade]; !ok {
+			return nil, fmt.Errorf("grade assignment %d: unknown grade %q in config file", i+1, ga.Grade)
+		}
+		if ga.Furnace == "" && ga.SampleName == "" {
+			return nil, fmt.Errorf("grade assignment %d: no furnace or sample_name in config file", i+1)
+		}
+		if _, err := regexp.Compile(ga.SampleName); err != nil {
+			return nil, fmt.Errorf("grade assignment %d: invalid sample_name in config file: %w", i+1, err)
+		}
+	}
+
+	if conf.Heats.SampleGap <= 0 {
+		conf.Heats.SampleGap = 60
+	}
+
+	if conf.SampleTypes == nil {
+		conf.SampleTypes = defaultSampleTypes
+	}
+	for i, st := range conf.SampleTypes {
+		if !sample.ValidType(st.Type) {
+			return nil, fmt.Errorf("sample type %d: unknown type %q in config file", i+1, st.Type)
+		}
+		if _, err := regexp.Compile(st.SampleName); err != nil {
+			return nil, fmt.Errorf("sample type %d: invalid sample_name in config file: %w", i+1, err)
+		}
+	}
+
+	for i, nf := range conf.SampleNameFormats {
+		if err := validateSampleNameFormat(&nf); err != nil {
+			return nil, fmt.Errorf("sample name format %d: %w", i+1, err)
+		}
+	}
+
+	if conf.ShopwareDB.Address != "" {
+		if conf.ShopwareDB.Table == "" {
+			return nil, errors.New("no remote_database table in config file")
+		}
+		setShopwareColumnDefaults(&conf.ShopwareDB.Columns)
 	}
 
 	return &conf, nil
 }
+
+func validateSampleNameFormat(nf *SampleNameFormat) error {
+	re, err := regexp.Compile(nf.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern in config file: %w", err)
+	}
+
+	for _, group := range re.SubexpNames()[1:] {
+		known := group == ""
+		for _, f := range SampleNameFields {
+			known = known || group == f
+		}
+		if !known {
+			return fmt.Errorf("unknown field %q in pattern in config file. Valid fields: %s", group, strings.Join(SampleNameFields, ", "))
+		}
+	}
+
+	for code, t := range nf.Types {
+		if !sample.ValidType(t) {
+			return fmt.Errorf("unknown type %q for %q in config file", t, code)
+		}
+	}
+	return nil
+}
+
+func setShopwareColumnDefaults(c *ShopwareColumns) {
+	if c.TimeStamp == "" {
+		c.TimeStamp = "DateTimeStamp"
+	}
+	if c.SampleName == "" {
+		c.SampleName = "SampleName"
+	}
+	if c.Furnace == "" {
+		c.Furnace = "Furname"
+	}
+	if c.Spectro == "" {
+		c.Spectro = "Spectro"
+	}
+	if c.Elements == nil {
+		c.Elements = make(map[string]string, len(defaultShopwareElements))
+		for _, el := range defaultShopwareElements {
+			c.Elements[el] = el
+		}
+	}
+}
+
+// Spectro of remote_machine_address in config files that predate remote_spectro_number.
+const defaultRemoteSpectro = 3
+
+// sources from config files that predate the sources list.
+func legacySources(conf *Config) []Source {
+	local := Source{Type: SourceXML, SpectroNumber: conf.SpectroNumber, DataSource: conf.DataSource}
+	if strings.Contains(strings.ToLower(conf.DataSource), "provider=") {
+		local.Type = SourceMDB
+	}
+
+	sources := []Source{local}
+	if conf.RemoteMachineAddress != "" {
+		if conf.RemoteSpectroNumber == 0 {
+			conf.RemoteSpectroNumber = defaultRemoteSpectro
+		}
+		sources = append(sources, Source{Type: SourceRemote, SpectroNumber: conf.RemoteSpectroNumber, DataSource: conf.RemoteMachineAddress})
+	}
+
+	return sources
+}
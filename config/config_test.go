@@ -0,0 +1,30 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadShippedConfigs(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "configs_New", "*", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no shipped configs found")
+	}
+
+	for _, file := range files {
+		conf, err := LoadConfig(file)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		for _, s := range conf.Sources {
+			if s.Type == SourceRemote && s.SpectroNumber != defaultRemoteSpectro {
+				t.Errorf("%s: expected remote source of spectro %d, got %d", file, defaultRemoteSpectro, s.SpectroNumber)
+			}
+		}
+	}
+}
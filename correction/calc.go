@@ -0,0 +1,197 @@
+package correction
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/grade"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+const solverIterations = 2000
+
+// ErrInvalid is returned for correction requests that cannot be calculated, like an unknown grade.
+var ErrInvalid = errors.New("invalid correction")
+
+// Result of a furnace correction calculation.
+type Result struct {
+	Furnace    string         `json:"furnace"`
+	Grade      string         `json:"grade"`
+	BathWeight float64        `json:"bath_weight"` // kg, before additions
+	Sample     *sample.Record `json:"sample"`      // the furnace's latest sample the correction is based on
+	Additions  []Addition     `json:"additions"`
+	Elements   []Prediction   `json:"elements"`
+}
+
+type Addition struct {
+	Material string  `json:"material"`
+	Weight   float64 `json:"weight"` // kg
+}
+
+// Prediction of an element's value after additions.
+type Prediction struct {
+	Element   string  `json:"element"`
+	Current   float64 `json:"current"`
+	Target    float64 `json:"target"`
+	Predicted float64 `json:"predicted"`
+	Status    string  `json:"status"` // against grade limits, after additions
+}
+
+// Calculate recommends addition weights that bring the elements of a bath that are
+// outside of their grade's warning limits (or limits, if no warning limits) to their aim.
+// It is a mass balance of the bath and additions, assuming all addition weight joins the bath.
+// Elements can only be lowered by dilution with materials low in them.
+func Calculate(s *sample.Record, bathWeight float64, limits map[string]config.ElementLimits, materials []config.AdditionMaterial) (*Result, error) {
+	if bathWeight <= 0 {
+		return nil, fmt.Errorf("%w: bath weight must be positive", ErrInvalid)
+	}
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("%w: grade has no element limits", ErrInvalid)
+	}
+
+	elements := make([]string, 0, len(limits))
+	for el := range limits {
+		if _, ok := s.ResultsMap[el]; ok {
+			elements = append(elements, el)
+		}
+	}
+	sort.Strings(elements)
+
+	res := &Result{
+		Furnace:    s.Furnace,
+		BathWeight: bathWeight,
+		Sample:     s,
+		Additions:  make([]Addition, len(materials)),
+		Elements:   make([]Prediction, len(elements)),
+	}
+
+	// mass balance for each element e that needs correcting, with x the weight of each material m:
+	// sum(x[m] * (comp[m][e] * rec[m][e] - target[e])) = bathWeight * (target[e] - current[e])
+	var a [][]float64
+	var b []float64
+	for i, el := range elements {
+		l := limits[el]
+		p := &res.Elements[i]
+		p.Element, p.Current, p.Target = el, s.ResultsMap[el], target(l)
+
+		if !needsCorrection(l, p.Current) {
+			continue
+		}
+
+		row := make([]float64, len(materials))
+		for m := range materials {
+			row[m] = effective(&materials[m], el) - p.Target
+		}
+		a = append(a, row)
+		b = append(b, bathWeight*(p.Target-p.Current))
+	}
+
+	x := nnls(a, b, len(materials))
+
+	totalWeight := bathWeight
+	for m := range materials {
+		res.Additions[m] = Addition{Material: materials[m].Name, Weight: x[m]}
+		totalWeight += x[m]
+	}
+
+	for i := range res.Elements {
+		p := &res.Elements[i]
+		mass := bathWeight * p.Current
+		for m := range materials {
+			mass += x[m] * effective(&materials[m], p.Element)
+		}
+		p.Predicted = mass / totalWeight
+		p.Status = grade.Status(limits[p.Element], p.Predicted)
+	}
+
+	return res, nil
+}
+
+// effective % of element in material that ends up in the bath.
+func effective(m *config.AdditionMaterial, el string) float64 {
+	rec, ok := m.Recovery[el]
+	if !ok {
+		rec = 1
+	}
+	return m.Composition[el] * rec
+}
+
+func target(l config.ElementLimits) float64 {
+	switch {
+	case l.Aim != nil:
+		return *l.Aim
+	case l.WarnMin != nil && l.WarnMax != nil:
+		return (*l.WarnMin + *l.WarnMax) / 2
+	case l.Min != nil && l.Max != nil:
+		return (*l.Min + *l.Max) / 2
+	case l.WarnMin != nil:
+		return *l.WarnMin
+	case l.Min != nil:
+		return *l.Min
+	case l.WarnMax != nil:
+		return *l.WarnMax
+	case l.Max != nil:
+		return *l.Max
+	}
+	return 0
+}
+
+func needsCorrection(l config.ElementLimits, v float64) bool {
+	return grade.Status(l, v) != sample.StatusOK
+}
+
+// nnls solves min |a*x - b| for x >= 0 with coordinate descent.
+func nnls(a [][]float64, b []float64, n int) []float64 {
+	x := make([]float64, n)
+	if len(a) == 0 {
+		return x
+	}
+
+	// residual r = a*x - b
+	r := make([]float64, len(b))
+	for i := range b {
+		r[i] = -b[i]
+	}
+
+	colSq := make([]float64, n)
+	for j := 0; j < n; j++ {
+		for i := range a {
+			colSq[j] += a[i][j] * a[i][j]
+		}
+	}
+
+	for it := 0; it < solverIterations; it++ {
+		maxStep := 0.0
+		for j := 0; j < n; j++ {
+			if colSq[j] == 0 {
+				continue
+			}
+
+			grad := 0.0
+			for i := range a {
+				grad += a[i][j] * r[i]
+			}
+
+			xj := math.Max(0, x[j]-grad/colSq[j])
+			step := xj - x[j]
+			if step == 0 {
+				continue
+			}
+
+			for i := range a {
+				r[i] += a[i][j] * step
+			}
+			x[j] = xj
+			maxStep = math.Max(maxStep, math.Abs(step))
+		}
+
+		if maxStep < 1e-9 {
+			break
+		}
+	}
+
+	return x
+}
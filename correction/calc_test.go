@@ -0,0 +1,61 @@
+package correction
+
+import (
+	"math"
+	"testing"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestCalculate(t *testing.T) {
+	limits := map[string]config.ElementLimits{
+		"C":  {Min: f(3.2), Max: f(3.6), Aim: f(3.4)},
+		"Si": {Min: f(1.8), Max: f(2.4), WarnMin: f(1.9), WarnMax: f(2.3)},
+		"Mn": {Max: f(0.8)},
+	}
+	materials := []config.AdditionMaterial{
+		{Name: "Carbon raiser", Composition: map[string]float64{"C": 100}, Recovery: map[string]float64{"C": 0.9}},
+		{Name: "FeSi75", Composition: map[string]float64{"Si": 75}},
+	}
+
+	// only carbon is low
+	s := &sample.Record{Furnace: "F1", ResultsMap: map[string]float64{"C": 3.0, "Si": 2.1, "Mn": 0.5}}
+	res, err := Calculate(s, 1000, limits, materials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// x * (100*0.9 - 3.4) = 1000 * (3.4 - 3.0)
+	if want := 400 / 86.6; math.Abs(res.Additions[0].Weight-want) > 1e-6 {
+		t.Fatalf("expected %.4f kg carbon raiser, got %.4f", want, res.Additions[0].Weight)
+	}
+	if res.Additions[1].Weight != 0 {
+		t.Fatalf("expected no FeSi, got %.4f", res.Additions[1].Weight)
+	}
+	for _, p := range res.Elements {
+		if p.Status == sample.StatusOutOfSpec {
+			t.Fatalf("unexpected predicted status: %+v", p)
+		}
+		if p.Element == "C" && math.Abs(p.Predicted-3.4) > 1e-6 {
+			t.Fatalf("expected C to reach aim, got %+v", p)
+		}
+	}
+
+	// both carbon and silicon low
+	s.ResultsMap = map[string]float64{"C": 3.1, "Si": 1.7}
+	if res, err = Calculate(s, 2000, limits, materials); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range res.Elements {
+		if math.Abs(p.Predicted-p.Target) > 1e-4 {
+			t.Fatalf("expected %s to reach target, got %+v", p.Element, p)
+		}
+	}
+
+	if _, err = Calculate(s, 0, limits, materials); err == nil {
+		t.Fatal("expected error for zero bath weight")
+	}
+}
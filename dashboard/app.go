@@ -0,0 +1,535 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/export"
+	"github.com/RoanBrand/SpectroDashboard/grade"
+	"github.com/RoanBrand/SpectroDashboard/history"
+	"github.com/RoanBrand/SpectroDashboard/http"
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/mdb_spectro"
+	"github.com/RoanBrand/SpectroDashboard/metrics"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/samplename"
+	"github.com/RoanBrand/SpectroDashboard/shopwaredb"
+	"github.com/RoanBrand/SpectroDashboard/xml_spectro/fileparser"
+	"github.com/kardianos/service"
+)
+
+type app struct {
+	conf    *config.Config
+	sdb     *shopwaredb.ShopwareDB
+	history *history.Store
+	specs   *grade.Specs
+	names   *samplename.Parser
+	sources []source
+
+	ctx  context.Context
+	ctxD context.CancelFunc
+
+	health healthState
+
+	// result cache
+	cLock          sync.RWMutex
+	cExpires       time.Time
+	cResult        []byte
+	cResultSources []byte        // cResult with the status of sources
+	cLatest        latestResults // of cResult, with all results read, to filter by sample type
+
+	furnaceCache furnaceCache
+}
+
+type source struct {
+	sample.ResultSource                     // instrumented
+	raw                 sample.ResultSource // for optional interfaces
+	conf                *config.Source
+	state               *sourceState
+}
+
+// sources that keep themselves up to date in the background, like the xml folder index.
+type watcher interface {
+	Watch(ctx context.Context)
+}
+
+func NewApp() *app {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &app{ctx: ctx, ctxD: cancel}
+}
+
+func (a *app) Start(s service.Service) error {
+	go a.startup()
+	return nil
+}
+
+func (a *app) startup() {
+	execPath, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+
+	conf, err := config.LoadConfig(filepath.Join(filepath.Dir(execPath), "config.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	a.conf = conf
+	log.Setup(filepath.Join(filepath.Dir(execPath), "spectrodashboard.log"), conf.DebugMode)
+
+	if a.specs, err = grade.NewSpecs(conf); err != nil {
+		panic(err)
+	}
+	if a.names, err = samplename.NewParser(conf); err != nil {
+		panic(err)
+	}
+
+	a.sources = make([]source, len(conf.Sources))
+	for i := range conf.Sources {
+		raw := newResultSource(&conf.Sources[i], a.names)
+		a.sources[i] = source{
+			ResultSource: metrics.InstrumentSource(raw, conf.Sources[i].Type),
+			raw:          raw,
+			conf:         &conf.Sources[i],
+			state:        &sourceState{},
+		}
+		if w, ok := raw.(watcher); ok {
+			go w.Watch(a.ctx)
+		}
+	}
+
+	if conf.ShopwareDB.Address != "" {
+		if a.sdb, err = shopwaredb.SetupShopwareDB(conf, dataFile(conf.OutboxFile, "outbox.db")); err != nil {
+			panic(err)
+		}
+		go a.sdb.Run(a.ctx)
+	}
+
+	if a.history, err = history.Open(dataFile(conf.HistoryFile, "history.db")); err != nil {
+		log.Println("running without sample history:", err)
+	}
+
+	go a.runRoutineJob()
+	a.watchNewSamples()
+
+	http.SetupServer(
+		filepath.Join(filepath.Dir(execPath), "static"),
+		a.getResultsAPI,
+		a.getLastFurnaceResultsAPI,
+		a.getResultAPI,
+	)
+	if a.history != nil {
+		http.SetupSampleQuery(a.querySamplesAPI)
+		http.SetupExport(a.exportSamplesAPI, conf.ElementsToDisplay,
+			export.NewCSV(conf.Export.CSVDelimiter, conf.Export.CSVDecimalSeparator))
+		http.SetupHeats(a.getHeatsAPI)
+	}
+	http.SetupCorrection(a.getCorrectionAPI)
+	http.SetupCertificate(a.getCertificateAPI)
+	http.SetupHealth(a.getHealthAPI)
+
+	if err = http.StartServer(conf.HTTPServerPort); err != nil {
+		panic(err)
+	}
+}
+
+func (a *app) Stop(s service.Service) error {
+	a.ctxD()
+	var err1 error
+	if err := http.StopServer(); err != nil {
+		err1 = fmt.Errorf("failed to stop http server: %w", err)
+	}
+
+	return errors.Join(err1, a.sdb.Stop(), a.history.Close())
+}
+
+func newResultSource(sc *config.Source, names *samplename.Parser) sample.ResultSource {
+	switch sc.Type {
+	case config.SourceMDB:
+		return mdb_spectro.NewSource(sc.DataSource, sc.SpectroNumber, names)
+	case config.SourceXML:
+		return fileparser.NewSource(sc.DataSource, sc.SpectroNumber, names)
+	default:
+		return http.NewRemoteSource(sc.DataSource, sc.SpectroNumber, sc.TimeoutDuration(), names)
+	}
+}
+
+const routineJobInterval = time.Second * 30
+
+func (a *app) runRoutineJob() {
+	t := time.NewTimer(routineJobInterval)
+
+	for {
+		select {
+		case <-t.C:
+			if _, err := a.getResultsAPI("", false); err != nil {
+				log.Println("failed to run routine job:", err)
+			} else {
+				a.routineJobDone()
+			}
+
+			t.Reset(routineJobInterval)
+
+		case <-a.ctx.Done():
+			if !t.Stop() {
+				<-t.C
+			}
+			return
+		}
+	}
+}
+
+// getResultsAPI returns the latest results, of only a sample type if not empty,
+// with the status of sources if withSources.
+func (a *app) getResultsAPI(sampleType string, withSources bool) ([]byte, error) {
+	// check if cache recent enough
+	a.cLock.RLock()
+	if time.Now().Before(a.cExpires) {
+		defer a.cLock.RUnlock()
+		metrics.ResultsCacheRequests.WithLabelValues("hit").Inc()
+		return a.cachedResults(sampleType, withSources)
+	}
+
+	// is old, get write lock and perform request
+	a.cLock.RUnlock()
+	a.cLock.Lock()
+	defer a.cLock.Unlock()
+
+	// need to check if result still old, otherwise return new result
+	if time.Now().Before(a.cExpires) {
+		metrics.ResultsCacheRequests.WithLabelValues("hit").Inc()
+		return a.cachedResults(sampleType, withSources)
+	}
+	metrics.ResultsCacheRequests.WithLabelValues("miss").Inc()
+
+	allResults, err := a.getLatestResults()
+	if err != nil {
+		return nil, err
+	}
+
+	// limit results after merge for tv api
+	latest := latestResults{Samples: limitResults(allResults, a.conf.NumberOfResults), Sources: a.sourceStatuses()}
+	resJson, err := json.Marshal(latest.Samples)
+	if err != nil {
+		return nil, err
+	}
+	resSourcesJson, err := json.Marshal(&latest)
+	if err != nil {
+		return nil, err
+	}
+
+	latest.Samples = allResults
+	a.cResult, a.cResultSources, a.cLatest = resJson, resSourcesJson, latest
+	a.cExpires = time.Now().Add(time.Second * 5)
+	return a.cachedResults(sampleType, withSources)
+}
+
+// cachedResults returns the cached results, of only a sample type if not empty,
+// with the status of sources if withSources. Requires cLock.
+// The latest results of a type are read from the history if available,
+// as they can be older than the latest results read from sources.
+func (a *app) cachedResults(sampleType string, withSources bool) ([]byte, error) {
+	if sampleType == "" {
+		if withSources {
+			return a.cResultSources, nil
+		}
+		return a.cResult, nil
+	}
+
+	ofType := latestResults{Sources: a.cLatest.Sources}
+	if a.history != nil {
+		page, err := a.history.Query(history.Query{Type: sampleType, Limit: a.conf.NumberOfResults})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Samples {
+			a.prepare(r)
+		}
+		ofType.Samples = page.Samples
+	} else {
+		ofType.Samples = make([]*sample.Record, 0, a.conf.NumberOfResults)
+		for _, r := range a.cLatest.Samples {
+			if r.Type == sampleType {
+				ofType.Samples = append(ofType.Samples, r)
+			}
+		}
+		ofType.Samples = limitResults(ofType.Samples, a.conf.NumberOfResults)
+	}
+
+	if withSources {
+		return json.Marshal(&ofType)
+	}
+	return json.Marshal(ofType.Samples)
+}
+
+func limitResults(recs []*sample.Record, n int) []*sample.Record {
+	if len(recs) > n {
+		return recs[:n]
+	}
+	return recs
+}
+
+// gets latest results from all sources, merged latest first, up to NumberOfResults of each source.
+// New results of sources that do not report them are published to clients.
+// Results from local sources are inserted into shopware.
+// The last results of sources that fail are used, until they are read again.
+func (a *app) getLatestResults() ([]*sample.Record, error) {
+	sourceRes := make([][]*sample.Record, len(a.sources))
+	sourceErr := make([]error, len(a.sources))
+
+	var wg sync.WaitGroup
+	wg.Add(len(a.sources))
+	for i := range a.sources {
+		go func(i int) {
+			defer wg.Done()
+			sourceRes[i], sourceErr[i] = a.sources[i].LatestResults(a.conf.NumberOfResults)
+		}(i)
+	}
+	wg.Wait()
+
+	newResults := make([]*sample.Record, 0, len(a.sources)*a.conf.NumberOfResults)
+	var staleResults, added []*sample.Record
+	failed, stale := 0, 0
+
+	for i, s := range a.sources {
+		if err := sourceErr[i]; err != nil {
+			log.Println("Error retrieving results from", s.conf.DataSource, ":", err)
+			failed++
+			if last, ok := s.state.failed(err); ok {
+				staleResults = append(staleResults, last...)
+				stale++
+			}
+			continue
+		}
+
+		recs := sourceRes[i]
+		if len(recs) == 0 {
+			log.Println("0 results found in", s.conf.DataSource)
+		}
+		if _, ok := s.raw.(notifier); ok {
+			s.state.succeeded(recs)
+		} else {
+			added = append(added, s.state.succeeded(recs)...)
+		}
+
+		// queue all results for insert into remote table. Those already inserted are ignored.
+		if a.sdb != nil && s.conf.Local() {
+			if err := a.sdb.Enqueue(recs); err != nil {
+				log.Println("Error queueing new results for remote database:", err)
+			}
+		}
+
+		newResults = append(newResults, recs...)
+	}
+
+	if failed == len(a.sources) && stale == 0 {
+		return nil, errors.New("failed to retrieve results from all sources")
+	}
+
+	a.saveHistory(newResults)
+
+	for _, r := range newResults {
+		a.prepare(r)
+		a.sawSample(r.TimeStamp)
+	}
+
+	// new samples of sources that do not report them. The others publish them as they are produced.
+	if len(added) > 0 {
+		a.furnaceCache.invalidate()
+		countIngested(added)
+		sort.SliceStable(added, func(i, j int) bool {
+			return added[i].TimeStamp.Before(added[j].TimeStamp)
+		})
+		http.PublishSamples(added)
+	}
+
+	allResults := append(newResults, staleResults...)
+	sort.Slice(allResults, func(i, j int) bool {
+		return allResults[i].TimeStamp.After(allResults[j].TimeStamp)
+	})
+	return allResults, nil
+}
+
+// furnaceResult of /lastfurnaceresults.
+type furnaceResult struct {
+	*sample.Record
+	AgeMinutes float64 `json:"age_minutes"` // since sampled
+	SpecStatus string  `json:"spec_status,omitempty"`
+}
+
+func (a *app) getLastFurnaceResultsAPI(furnaces []string, sampleType string) (interface{}, error) {
+	recs, err := a.lastFurnaceResults(furnaces, sampleType)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res := make([]furnaceResult, len(recs))
+	for i, r := range recs {
+		res[i] = furnaceResult{
+			Record:     r,
+			AgeMinutes: math.Floor(now.Sub(r.TimeStamp).Minutes()),
+			SpecStatus: grade.SampleStatus(r),
+		}
+	}
+	return res, nil
+}
+
+// latest sample of each furnace over all sources, of only a sample type if not empty,
+// in order of furnaces requested, with display results.
+func (a *app) lastFurnaceResults(furnaces []string, sampleType string) ([]*sample.Record, error) {
+	return a.furnaceCache.get(furnaces, sampleType, func() ([]*sample.Record, error) {
+		recs, err := a.readLastFurnaceResults(furnaces, sampleType)
+		for _, r := range recs {
+			a.prepare(r)
+		}
+		return recs, err
+	})
+}
+
+func (a *app) readLastFurnaceResults(furnaces []string, sampleType string) ([]*sample.Record, error) {
+	sourceRes := make([][]*sample.Record, len(a.sources))
+	sourceErr := make([]error, len(a.sources))
+
+	var wg sync.WaitGroup
+	wg.Add(len(a.sources))
+	for i := range a.sources {
+		go func(i int) {
+			defer wg.Done()
+			sourceRes[i], sourceErr[i] = a.sources[i].LastFurnaceResults(furnaces, sampleType)
+		}(i)
+	}
+	wg.Wait()
+
+	latest := make(map[string]*sample.Record, len(furnaces))
+	failed := 0
+
+	for i, s := range a.sources {
+		if err := sourceErr[i]; err != nil {
+			log.Println("Error retrieving last furnace results from", s.conf.DataSource, ":", err)
+			failed++
+			continue
+		}
+
+		for _, r := range sourceRes[i] {
+			f := strings.ToUpper(r.Furnace)
+			if l, ok := latest[f]; ok && l.TimeStamp.After(r.TimeStamp) {
+				continue
+			}
+			latest[f] = r
+		}
+	}
+
+	if failed == len(a.sources) {
+		return nil, sourceErr[0]
+	}
+
+	lastFurnaceResults := make([]*sample.Record, 0, len(latest))
+	for _, f := range furnaces {
+		if r, ok := latest[strings.ToUpper(f)]; ok {
+			lastFurnaceResults = append(lastFurnaceResults, r)
+			delete(latest, strings.ToUpper(f))
+		}
+	}
+
+	return lastFurnaceResults, nil
+}
+
+func (a *app) getResultAPI(spectro int, id string) (*sample.Record, error) {
+	for _, s := range a.sources {
+		if s.Spectro() != spectro {
+			continue
+		}
+
+		r, err := s.ResultByID(id)
+		if err != nil {
+			if errors.Is(err, sample.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		a.prepare(r)
+		return r, nil
+	}
+
+	return nil, sample.ErrNotFound
+}
+
+// number of best matching grades reported with each sample.
+const gradeMatches = 3
+
+// prepare a sample for clients: lookup elements to display, check against its grade and identify closest grades.
+func (a *app) prepare(r *sample.Record) {
+	r.SetDisplayResults(a.conf.ElementOrder)
+	a.specs.Annotate(r)
+	r.GradeMatches = a.specs.Identify(r, gradeMatches)
+}
+
+func (a *app) querySamplesAPI(q history.Query) (*history.Page, error) {
+	page, err := a.history.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range page.Samples {
+		a.prepare(r)
+	}
+	return page, nil
+}
+
+// most samples returned by one export.
+const maxExportSamples = 100000
+
+// all samples matching q, over all pages.
+func (a *app) exportSamplesAPI(q history.Query) ([]*sample.Record, error) {
+	q.Limit = history.MaxLimit
+	var recs []*sample.Record
+
+	for len(recs) < maxExportSamples {
+		page, err := a.querySamplesAPI(q)
+		if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, page.Samples...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	return recs, nil
+}
+
+func countIngested(recs []*sample.Record) {
+	for _, r := range recs {
+		metrics.SamplesIngested.WithLabelValues(metrics.Spectro(r.Spectro)).Inc()
+	}
+}
+
+func (a *app) saveHistory(recs []*sample.Record) {
+	if a.history == nil {
+		return
+	}
+
+	added, err := a.history.Add(recs)
+	if err != nil {
+		log.Println("Error saving samples to history:", err)
+		return
+	}
+
+	if len(added) > 0 && a.conf.DebugMode {
+		log.Printf("saved %d new samples to history\n", len(added))
+	}
+}
@@ -0,0 +1,112 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/grade"
+	"github.com/RoanBrand/SpectroDashboard/history"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestCachedResultsOfType(t *testing.T) {
+	conf := &config.Config{NumberOfResults: 2}
+	specs, err := grade.NewSpecs(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{conf: conf, specs: specs}
+
+	// latest first, more than NumberOfResults
+	t0 := time.Date(2023, 5, 1, 8, 0, 0, 0, time.Local)
+	recs := []*sample.Record{
+		{SampleName: "4", Type: sample.TypeBath, TimeStamp: t0.Add(time.Minute * 4)},
+		{SampleName: "3", Type: sample.TypeBath, TimeStamp: t0.Add(time.Minute * 3)},
+		{SampleName: "2T", Type: sample.TypeTap, TimeStamp: t0.Add(time.Minute * 2)},
+		{SampleName: "1T", Type: sample.TypeTap, TimeStamp: t0.Add(time.Minute)},
+		{SampleName: "0T", Type: sample.TypeTap, TimeStamp: t0},
+	}
+	a.cLatest = latestResults{Samples: recs}
+
+	check := func(name string) {
+		t.Helper()
+		res, err := a.cachedResults(sample.TypeTap, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got latestResults
+		if err = json.Unmarshal(res, &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Samples) != 2 || got.Samples[0].SampleName != "2T" || got.Samples[1].SampleName != "1T" {
+			t.Fatalf("%s: unexpected tap results: %+v", name, got.Samples)
+		}
+	}
+	check("cached")
+
+	// older clients and services expect an array of samples
+	res, err := a.cachedResults(sample.TypeTap, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []*sample.Record
+	if err = json.Unmarshal(res, &samples); err != nil || len(samples) != 2 {
+		t.Fatalf("unexpected tap results without sources: %s %v", res, err)
+	}
+
+	if a.history, err = history.Open(filepath.Join(t.TempDir(), "history.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer a.history.Close()
+	if _, err = a.history.Add(recs); err != nil {
+		t.Fatal(err)
+	}
+	a.cLatest = latestResults{}
+	check("history")
+}
+
+func TestCertificateOfHeat(t *testing.T) {
+	conf := &config.Config{}
+	conf.Heats.SampleGap = 60
+	specs, err := grade.NewSpecs(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{conf: conf, specs: specs}
+	if a.history, err = history.Open(filepath.Join(t.TempDir(), "history.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer a.history.Close()
+
+	t0 := time.Date(2023, 5, 1, 8, 0, 0, 0, time.Local)
+	rec := func(min int, name, heat, typ string) *sample.Record {
+		return &sample.Record{SampleName: name, Furnace: "F1", Heat: heat, Type: typ, Spectro: 1, TimeStamp: t0.Add(time.Minute * time.Duration(min))}
+	}
+	if _, err = a.history.Add([]*sample.Record{
+		rec(0, "12-1", "12", sample.TypeBath),
+		rec(10, "12-2T", "12", sample.TypeTap),
+		rec(20, "12-3", "12", sample.TypeBath), // after tap
+		rec(30, "123-1T", "123", sample.TypeTap),
+		rec(40, "124-1", "124", sample.TypeBath),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := a.getCertificateAPI(0, "", "12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sample.SampleName != "12-2T" {
+		t.Fatalf("expected tap sample of heat 12, got %s", c.Sample.SampleName)
+	}
+
+	for _, h := range []string{"124", "1"} {
+		if _, err = a.getCertificateAPI(0, "", h); !errors.Is(err, sample.ErrNotFound) {
+			t.Errorf("heat %s: expected not found, got %v", h, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package dashboard
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/RoanBrand/SpectroDashboard/history"
+	"github.com/RoanBrand/SpectroDashboard/http"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/shopwaredb"
+)
+
+const backfillBatchSize = 100
+
+// backfill reads all samples of local sources over a time range, like from before the service was
+// installed, and writes those missing or different into Shopware and the sample history.
+func backfill(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "Start of time range, e.g. 2023-05-01 or 2023-05-01T08:00. Required.")
+	toFlag := fs.String("to", "", "End of time range, excluded. Default now.")
+	spectro := fs.Int("s", 0, "Only this spectro.")
+	sinksFlag := fs.String("sinks", "shopware,history", "Where to write samples: shopware and/or history.")
+	dryRun := fs.Bool("dry-run", false, "Only report what would be written.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *fromFlag == "" {
+		return errors.New("-from is required")
+	}
+	from, err := http.ParseTime(*fromFlag)
+	if err != nil {
+		return err
+	}
+	to, err := http.ParseTime(*toFlag)
+	if err != nil {
+		return err
+	}
+	if !to.IsZero() && !to.After(from) {
+		return errors.New("-to must be after -from")
+	}
+
+	toShopware, toHistory := false, false
+	for _, sink := range strings.Split(*sinksFlag, ",") {
+		switch strings.TrimSpace(sink) {
+		case "shopware":
+			toShopware = true
+		case "history":
+			toHistory = true
+		case "":
+		default:
+			return fmt.Errorf("unknown sink %q", sink)
+		}
+	}
+
+	conf, err := commandConfig()
+	if err != nil {
+		return err
+	}
+
+	sources, err := rangeSources(conf, *spectro)
+	if err != nil {
+		return err
+	}
+
+	var sdb *shopwaredb.ShopwareDB
+	if toShopware {
+		if conf.ShopwareDB.Address == "" {
+			return errors.New("no remote_database in config")
+		}
+		if sdb, err = shopwaredb.Connect(conf); err != nil {
+			return err
+		}
+		defer sdb.Stop()
+	}
+
+	var store *history.Store
+	if toHistory {
+		if store, err = history.Open(dataFile(conf.HistoryFile, "history.db")); err != nil {
+			return fmt.Errorf("%w (stop the service while backfilling history)", err)
+		}
+		defer store.Close()
+	}
+
+	for _, src := range sources {
+		recs, err := src.ResultsBetween(from, to)
+		if err != nil {
+			return fmt.Errorf("failed reading samples of spectro %d: %w", src.Spectro(), err)
+		}
+		fmt.Fprintf(out, "spectro %d: %d samples at source\n", src.Spectro(), len(recs))
+
+		if sdb != nil {
+			d, err := sdb.Compare(src.Spectro(), from, to, recs)
+			if err != nil {
+				return err
+			}
+
+			toWrite := d.ToWrite()
+			fmt.Fprintf(out, "spectro %d: %d missing and %d different in Shopware\n", d.Spectro, len(d.Missing), len(d.Mismatched))
+			if !*dryRun {
+				err = inBatches(out, src.Spectro(), "Shopware", toWrite, func(batch []*sample.Record) error {
+					_, err := sdb.Write(src.Spectro(), batch)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if store != nil {
+			newRecs, err := store.New(recs)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "spectro %d: %d missing in history\n", src.Spectro(), len(newRecs))
+			if !*dryRun {
+				err = inBatches(out, src.Spectro(), "history", newRecs, func(batch []*sample.Record) error {
+					_, err := store.Add(batch)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if *dryRun {
+		fmt.Fprintln(out, "dry run: nothing written")
+	}
+	return nil
+}
+
+// inBatches calls write with consecutive batches of recs, reporting progress after each.
+func inBatches(out io.Writer, spectro int, sink string, recs []*sample.Record, write func(batch []*sample.Record) error) error {
+	for i := 0; i < len(recs); i += backfillBatchSize {
+		end := i + backfillBatchSize
+		if end > len(recs) {
+			end = len(recs)
+		}
+
+		if err := write(recs[i:end]); err != nil {
+			return fmt.Errorf("spectro %d: failed writing to %s after %d of %d samples: %w", spectro, sink, i, len(recs), err)
+		}
+		fmt.Fprintf(out, "spectro %d: wrote %d/%d samples to %s (%d%%)\n", spectro, end, len(recs), sink, end*100/len(recs))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/certificate"
+	"github.com/RoanBrand/SpectroDashboard/heat"
+	"github.com/RoanBrand/SpectroDashboard/history"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// getCertificateAPI creates a certificate of analysis for a sample, or for the tap sample of a heat.
+// The heat is the latest in the history with the heat number, within heatsPeriod before its last sample.
+func (a *app) getCertificateAPI(spectro int, id, heatNumber string) (*certificate.Certificate, error) {
+	var s *sample.Record
+
+	if heatNumber != "" {
+		if a.history == nil {
+			return nil, sample.ErrNotFound
+		}
+
+		page, err := a.history.Query(history.Query{Heat: heatNumber, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Samples) == 0 {
+			return nil, fmt.Errorf("%w: no samples of heat %s", sample.ErrNotFound, heatNumber)
+		}
+
+		last := page.Samples[0]
+		recs, err := a.heatSamples(last.Furnace, last.TimeStamp.Add(-heatsPeriod), last.TimeStamp.Add(time.Nanosecond),
+			func(r *sample.Record) bool {
+				return strings.EqualFold(r.Heat, heatNumber)
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, h := range heat.Group(recs, a.heatGap()) {
+			if h.Tap != nil {
+				s = h.Tap
+				break
+			}
+		}
+		if s == nil {
+			return nil, fmt.Errorf("%w: no tap sample of heat %s", sample.ErrNotFound, heatNumber)
+		}
+	} else {
+		var err error
+		if s, err = a.getResultAPI(spectro, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return certificate.New(a.conf, s, heatNumber, a.specs.Limits(s.Grade)), nil
+}
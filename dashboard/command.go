@@ -0,0 +1,71 @@
+package dashboard
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/samplename"
+)
+
+// Command runs a once off command, instead of the service.
+func Command(name string, args []string) error {
+	switch name {
+	case "reconcile":
+		return reconcile(args, os.Stdout)
+	case "backfill":
+		return backfill(args, os.Stdout)
+	}
+	return fmt.Errorf("unknown command %q. Valid commands: reconcile, backfill", name)
+}
+
+// commandConfig loads the service's config, next to the executable.
+func commandConfig() (*config.Config, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return config.LoadConfig(filepath.Join(filepath.Dir(execPath), "config.json"))
+}
+
+// dataFile returns the configured file, or the default file name next to the executable.
+func dataFile(configured, name string) string {
+	if configured != "" {
+		return configured
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(filepath.Dir(execPath), name)
+}
+
+// rangeSources returns the local sources that can read samples over a time range,
+// of only the given spectro if not 0.
+func rangeSources(conf *config.Config, spectro int) ([]sample.RangeSource, error) {
+	names, err := samplename.NewParser(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []sample.RangeSource
+	for i := range conf.Sources {
+		sc := &conf.Sources[i]
+		if !sc.Local() || (spectro != 0 && sc.SpectroNumber != spectro) {
+			continue
+		}
+		if rs, ok := newResultSource(sc, names).(sample.RangeSource); ok {
+			sources = append(sources, rs)
+		}
+	}
+
+	if len(sources) == 0 {
+		if spectro != 0 {
+			return nil, fmt.Errorf("no local source for spectro %d in config", spectro)
+		}
+		return nil, fmt.Errorf("no local sources in config")
+	}
+	return sources, nil
+}
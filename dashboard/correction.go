@@ -0,0 +1,54 @@
+package dashboard
+
+import (
+	"fmt"
+
+	"github.com/RoanBrand/SpectroDashboard/correction"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// getCorrectionAPI calculates additions for a furnace from its latest sample.
+// Grade defaults to the sample's assigned grade, and bath weight to the furnace's config.
+func (a *app) getCorrectionAPI(furnace, gradeName string, bathWeight float64) (interface{}, error) {
+	last, err := a.lastFurnaceResults([]string{furnace}, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(last) == 0 {
+		return nil, sample.ErrNotFound
+	}
+
+	// furnace results of older remote services have no element results
+	s := last[0]
+	if len(s.ResultsMap) == 0 {
+		if s, err = a.getResultAPI(s.Spectro, s.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if gradeName == "" {
+		if gradeName = s.Grade; gradeName == "" {
+			return nil, fmt.Errorf("%w: no grade assigned to furnace %s", correction.ErrInvalid, furnace)
+		}
+	}
+	limits := a.specs.Limits(gradeName)
+	if limits == nil {
+		return nil, fmt.Errorf("%w: unknown grade %q", correction.ErrInvalid, gradeName)
+	}
+
+	if bathWeight == 0 {
+		f, ok := a.conf.Furnace(furnace)
+		if !ok || f.BathWeight == 0 {
+			return nil, fmt.Errorf("%w: no bath weight configured for furnace %s", correction.ErrInvalid, furnace)
+		}
+		bathWeight = f.BathWeight
+	}
+
+	res, err := correction.Calculate(s, bathWeight, limits, a.conf.AdditionMaterials)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Grade = gradeName
+	return res, nil
+}
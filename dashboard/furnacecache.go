@@ -0,0 +1,87 @@
+package dashboard
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/metrics"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Last furnace results are cached until sources have new samples, or at most this long.
+const furnaceCacheTTL = time.Minute
+
+// furnaceCache caches the last furnace results of each set of furnaces and sample type requested.
+// Concurrent requests for the same set wait for a single read of the sources.
+type furnaceCache struct {
+	mu         sync.Mutex
+	generation uint64 // incremented when invalidated
+	entries    map[string]*furnaceEntry
+}
+
+type furnaceEntry struct {
+	done       chan struct{} // closed when read
+	generation uint64
+	expires    time.Time
+	recs       []*sample.Record
+	err        error
+}
+
+// get returns the cached results for the furnaces, or reads them with read.
+func (c *furnaceCache) get(furnaces []string, sampleType string, read func() ([]*sample.Record, error)) ([]*sample.Record, error) {
+	key := furnaceCacheKey(furnaces, sampleType)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && e.generation == c.generation && e.fresh() {
+		c.mu.Unlock()
+		metrics.FurnaceCacheRequests.WithLabelValues("hit").Inc()
+		<-e.done
+		return e.recs, e.err
+	}
+
+	e := &furnaceEntry{done: make(chan struct{}), generation: c.generation}
+	if c.entries == nil {
+		c.entries = make(map[string]*furnaceEntry)
+	}
+	c.entries[key] = e
+	c.mu.Unlock()
+	metrics.FurnaceCacheRequests.WithLabelValues("miss").Inc()
+
+	e.recs, e.err = read()
+	e.expires = time.Now().Add(furnaceCacheTTL)
+	close(e.done)
+
+	if e.err != nil {
+		// let the next request try again
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+
+	return e.recs, e.err
+}
+
+// fresh is true while being read, or if read successfully and not expired.
+func (e *furnaceEntry) fresh() bool {
+	select {
+	case <-e.done:
+		return e.err == nil && time.Now().Before(e.expires)
+	default:
+		return true
+	}
+}
+
+// invalidate cached results, as new samples were ingested. Reads in progress are not reused.
+func (c *furnaceCache) invalidate() {
+	c.mu.Lock()
+	c.generation++
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+func furnaceCacheKey(furnaces []string, sampleType string) string {
+	return strings.ToUpper(strings.Join(furnaces, "\x00")) + "\x00" + sampleType
+}
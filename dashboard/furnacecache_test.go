@@ -0,0 +1,72 @@
+package dashboard
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestFurnaceCache(t *testing.T) {
+	var c furnaceCache
+	var reads int32
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	read := func() ([]*sample.Record, error) {
+		atomic.AddInt32(&reads, 1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return []*sample.Record{{Furnace: "F1"}}, nil
+	}
+
+	// concurrent requests for the same furnaces share one read
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if recs, err := c.get([]string{"F1", "f2"}, "", read); err != nil || len(recs) != 1 {
+				t.Errorf("unexpected results: %+v %v", recs, err)
+			}
+		}()
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for read")
+	}
+	close(release)
+	wg.Wait()
+	if reads != 1 {
+		t.Fatalf("expected 1 read, got %d", reads)
+	}
+
+	c.get([]string{"f1", "F2"}, "", read)
+	if reads != 1 {
+		t.Fatalf("expected cached results, got %d reads", reads)
+	}
+	c.get([]string{"F1", "F2"}, sample.TypeTap, read)
+	if reads != 2 {
+		t.Fatalf("expected read of other request, got %d reads", reads)
+	}
+
+	c.invalidate()
+	c.get([]string{"F1", "F2"}, "", read)
+	if reads != 3 {
+		t.Fatalf("expected read after invalidate, got %d reads", reads)
+	}
+
+	failed := func() ([]*sample.Record, error) {
+		atomic.AddInt32(&reads, 1)
+		return nil, errors.New("unreachable")
+	}
+	c.get([]string{"F3"}, "", failed)
+	if _, err := c.get([]string{"F3"}, "", failed); err == nil || reads != 5 {
+		t.Fatalf("expected failed read to be retried, got %v after %d reads", err, reads)
+	}
+}
@@ -0,0 +1,130 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/health"
+)
+
+// Shopware is unhealthy when samples wait this long to be inserted.
+const shopwareMaxPendingAge = time.Minute * 5
+
+// sources that can check if they are reachable and readable.
+type checker interface {
+	Check() error
+}
+
+// health state updated as the service runs.
+type healthState struct {
+	sync.Mutex
+	lastRoutineJob time.Time // last successful
+	newestSample   time.Time
+}
+
+func (a *app) routineJobDone() {
+	a.health.Lock()
+	a.health.lastRoutineJob = time.Now()
+	a.health.Unlock()
+}
+
+func (a *app) sawSample(ts time.Time) {
+	a.health.Lock()
+	if ts.After(a.health.newestSample) {
+		a.health.newestSample = ts
+	}
+	a.health.Unlock()
+}
+
+func (a *app) getHealthAPI() *health.Report {
+	report := &health.Report{Status: health.StatusOK, CheckedAt: time.Now()}
+
+	a.health.Lock()
+	lastRoutineJob := a.health.lastRoutineJob
+	newestSample := a.health.newestSample
+	a.health.Unlock()
+
+	// check dependencies concurrently, as remote ones may time out.
+	components := make([]health.Component, len(a.sources)+1)
+	var wg sync.WaitGroup
+
+	for i, s := range a.sources {
+		c := &components[i]
+		c.Name = "source " + s.conf.Type + " spectro " + strconv.Itoa(s.Spectro())
+		c.Status = health.StatusOK
+
+		ch, ok := s.raw.(checker)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(ch checker) {
+			defer wg.Done()
+			if err := ch.Check(); err != nil {
+				c.Status, c.Detail = health.StatusDown, err.Error()
+			}
+		}(ch)
+	}
+
+	if a.sdb != nil {
+		c := &components[len(a.sources)]
+		c.Name, c.Status = "shopware", health.StatusOK
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if err := a.sdb.Ping(ctx); err != nil {
+				c.Status, c.Detail = health.StatusDown, err.Error()
+			} else if n, oldest := a.sdb.Pending(); n > 0 && time.Since(oldest) > shopwareMaxPendingAge {
+				c.Status, c.Detail = health.StatusWarning, fmt.Sprintf("%d samples waiting to be inserted, oldest from %s", n, oldest.Format(time.RFC3339))
+			} else if n = a.sdb.Dead(); n > 0 {
+				c.Status, c.Detail = health.StatusWarning, fmt.Sprintf("%d samples rejected too often and not retried, see dead bucket in outbox", n)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	sourcesUp := 0
+	for i, c := range components {
+		if c.Name == "" {
+			continue
+		}
+		if i < len(a.sources) && c.Status == health.StatusOK {
+			sourcesUp++
+		}
+		report.Add(c)
+	}
+
+	rj := health.Component{Name: "routine job", Status: health.StatusOK}
+	if lastRoutineJob.IsZero() {
+		rj.Status, rj.Detail = health.StatusWarning, "not yet run successfully"
+	} else {
+		rj.Detail = "last successful " + lastRoutineJob.Format(time.RFC3339)
+		if time.Since(lastRoutineJob) > routineJobInterval*3 {
+			rj.Status = health.StatusDown
+		}
+	}
+	report.Add(rj)
+
+	ns := health.Component{Name: "newest sample", Status: health.StatusOK}
+	if newestSample.IsZero() {
+		ns.Status, ns.Detail = health.StatusWarning, "no samples yet"
+	} else {
+		age := time.Since(newestSample).Truncate(time.Second)
+		ns.Detail = fmt.Sprintf("%s old, at %s", age, newestSample.Format(time.RFC3339))
+		if a.conf.Health.MaxSampleAge > 0 && age > time.Duration(a.conf.Health.MaxSampleAge)*time.Minute {
+			ns.Status = health.StatusWarning
+		}
+	}
+	report.Add(ns)
+
+	report.Ready = sourcesUp > 0
+	return report
+}
@@ -0,0 +1,71 @@
+package dashboard
+
+import (
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/heat"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Default period of heats, back from to.
+const heatsPeriod = time.Hour * 24
+
+// getHeatsAPI groups the samples in the history from, up to but excluding, to, into heats,
+// of only a furnace and heat number if not empty. Heats in progress at from are partial.
+func (a *app) getHeatsAPI(furnace, heatNumber string, from, to time.Time) (interface{}, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-heatsPeriod)
+	}
+
+	recs, err := a.heatSamples(furnace, from, to, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	heats := heat.Group(recs, a.heatGap())
+	if heatNumber != "" {
+		ofHeat := heats[:0]
+		for _, h := range heats {
+			if strings.EqualFold(h.Heat, heatNumber) {
+				ofHeat = append(ofHeat, h)
+			}
+		}
+		heats = ofHeat
+	}
+
+	return struct {
+		Heats []*heat.Heat `json:"heats"`
+	}{heats}, nil
+}
+
+// heatSamples returns samples in the history from, up to but excluding, to, in time order, of only a furnace
+// if not empty, and that match if not nil. Zero from or to is unbounded. Fields are parsed from sample names
+// again, for samples saved before name formats or type rules were configured, or changed.
+func (a *app) heatSamples(furnace string, from, to time.Time, match func(r *sample.Record) bool) ([]*sample.Record, error) {
+	var recs []*sample.Record
+	collect := func(r *sample.Record) bool {
+		a.names.Parse(r)
+		if match != nil && !match(r) {
+			return true
+		}
+		a.prepare(r)
+		recs = append(recs, r)
+		return len(recs) < maxExportSamples
+	}
+
+	var err error
+	if furnace != "" {
+		err = a.history.Furnace(furnace, from, to, collect)
+	} else {
+		err = a.history.Between(from, to, collect)
+	}
+	return recs, err
+}
+
+func (a *app) heatGap() time.Duration {
+	return time.Minute * time.Duration(a.conf.Heats.SampleGap)
+}
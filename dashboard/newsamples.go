@@ -0,0 +1,116 @@
+package dashboard
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/http"
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// period between probes of sources for new samples.
+const newSamplesProbeInterval = time.Second * 2
+
+// sources that report new samples themselves, like the xml folder index.
+type notifier interface {
+	OnNewSamples(fn func(added []*sample.Record))
+}
+
+// sources that can cheaply tell that they have new samples, like the latest sample ID of a spectro database.
+// New samples are found when getLatestResults reads them.
+type changeProbe interface {
+	LatestChange() (string, error)
+}
+
+// watchNewSamples publishes new samples to clients as soon as sources have them.
+func (a *app) watchNewSamples() {
+	probes := make([]changeProbe, 0, len(a.sources))
+	names := make([]string, 0, len(a.sources))
+	for _, s := range a.sources {
+		if n, ok := s.raw.(notifier); ok {
+			n.OnNewSamples(a.newSamples)
+		} else if p, ok := s.raw.(changeProbe); ok {
+			probes = append(probes, p)
+			names = append(names, s.conf.DataSource)
+		}
+	}
+
+	if len(probes) > 0 {
+		go a.probeNewSamples(probes, names)
+	}
+}
+
+// probeNewSamples reads the latest results as soon as a source changes, which publishes its new samples.
+func (a *app) probeNewSamples(probes []changeProbe, names []string) {
+	last := make([]string, len(probes))
+	t := time.NewTicker(newSamplesProbeInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-a.ctx.Done():
+			return
+		}
+
+		changed := false
+		for i, p := range probes {
+			if p == nil {
+				continue
+			}
+
+			v, err := p.LatestChange()
+			if err != nil {
+				if errors.Is(err, sample.ErrNotFound) {
+					log.Println(names[i], "can not be probed for new samples, they are found when results are read")
+					probes[i] = nil
+				} else if a.conf.DebugMode {
+					log.Println("failed to probe", names[i], "for new samples:", err)
+				}
+				continue
+			}
+
+			if last[i] != "" && v != last[i] {
+				changed = true
+			}
+			last[i] = v
+		}
+
+		if changed {
+			a.cLock.Lock()
+			a.cExpires = time.Time{}
+			a.cLock.Unlock()
+			if _, err := a.getResultsAPI("", false); err != nil {
+				log.Println("failed to read new samples:", err)
+			}
+		}
+	}
+}
+
+// newSamples is called with samples, oldest first, as they are produced by sources.
+func (a *app) newSamples(recs []*sample.Record) {
+	for _, r := range recs {
+		a.prepare(r)
+		a.sawSample(r.TimeStamp)
+	}
+
+	countIngested(recs)
+	a.saveHistory(recs)
+
+	// results caches are outdated
+	a.cLock.Lock()
+	a.cExpires = time.Time{}
+	a.cLock.Unlock()
+	a.furnaceCache.invalidate()
+
+	http.PublishSamples(recs)
+}
+
+func sampleKey(r *sample.Record) string {
+	if r.ID != "" {
+		return strconv.Itoa(r.Spectro) + "/" + r.ID
+	}
+	return strconv.Itoa(r.Spectro) + "/" + r.SampleName + "/" + r.TimeStamp.String()
+}
@@ -0,0 +1,113 @@
+package dashboard
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/http"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/shopwaredb"
+)
+
+// reconcile compares the samples of local sources with Shopware over a time range. It reports samples
+// missing from Shopware, extra in Shopware, and with different results, and optionally repairs them.
+func reconcile(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "Start of time range, e.g. 2023-05-01 or 2023-05-01T08:00. Default 24 hours ago.")
+	toFlag := fs.String("to", "", "End of time range, excluded. Default now.")
+	spectro := fs.Int("s", 0, "Only this spectro.")
+	repair := fs.Bool("repair", false, "Write missing and mismatched samples to Shopware. Extra samples are only reported.")
+	verbose := fs.Bool("v", false, "List every sample that differs.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := http.ParseTime(*fromFlag)
+	if err != nil {
+		return err
+	}
+	to, err := http.ParseTime(*toFlag)
+	if err != nil {
+		return err
+	}
+	if from.IsZero() {
+		from = time.Now().Add(-time.Hour * 24)
+	}
+	if !to.IsZero() && !to.After(from) {
+		return errors.New("-to must be after -from")
+	}
+
+	conf, err := commandConfig()
+	if err != nil {
+		return err
+	}
+	if conf.ShopwareDB.Address == "" {
+		return errors.New("no remote_database in config")
+	}
+
+	sources, err := rangeSources(conf, *spectro)
+	if err != nil {
+		return err
+	}
+
+	sdb, err := shopwaredb.Connect(conf)
+	if err != nil {
+		return err
+	}
+	defer sdb.Stop()
+
+	differences := 0
+	for _, src := range sources {
+		recs, err := src.ResultsBetween(from, to)
+		if err != nil {
+			return fmt.Errorf("failed reading samples of spectro %d: %w", src.Spectro(), err)
+		}
+
+		d, err := sdb.Compare(src.Spectro(), from, to, recs)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "spectro %d: %d matched, %d missing, %d extra, %d mismatched\n",
+			d.Spectro, d.Matched, len(d.Missing), len(d.Extra), len(d.Mismatched))
+		if *verbose {
+			printSamples(out, "missing", d.Missing)
+			printSamples(out, "extra", d.Extra)
+			for _, m := range d.Mismatched {
+				fmt.Fprintf(out, "  mismatched  %s  (%s)\n", sampleLine(m.Source), strings.Join(m.Fields, ", "))
+			}
+		}
+
+		if *repair {
+			n, err := sdb.Repair(d)
+			if err != nil {
+				return fmt.Errorf("failed repairing spectro %d: %w", d.Spectro, err)
+			}
+			if n > 0 {
+				fmt.Fprintf(out, "spectro %d: wrote %d samples to Shopware\n", d.Spectro, n)
+			}
+			differences += len(d.Extra)
+		} else {
+			differences += len(d.Missing) + len(d.Extra) + len(d.Mismatched)
+		}
+	}
+
+	if differences > 0 {
+		return fmt.Errorf("%d samples differ", differences)
+	}
+	return nil
+}
+
+func printSamples(out io.Writer, kind string, recs []*sample.Record) {
+	for _, r := range recs {
+		fmt.Fprintf(out, "  %-10s  %s\n", kind, sampleLine(r))
+	}
+}
+
+func sampleLine(r *sample.Record) string {
+	return fmt.Sprintf("%s  %-8s  %s", r.TimeStamp.Format("2006-01-02 15:04:05"), r.Furnace, r.SampleName)
+}
@@ -0,0 +1,107 @@
+package dashboard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Source statuses in /results.
+const (
+	sourceOK      = "ok"
+	sourceStale   = "stale"   // unreachable, its last results are served
+	sourceOffline = "offline" // unreachable, with no results to serve
+)
+
+// latest results of /results, with the status of each source.
+type latestResults struct {
+	Samples []*sample.Record `json:"samples"`
+	Sources []sourceStatus   `json:"sources"`
+}
+
+type sourceStatus struct {
+	Spectro      int        `json:"spectro"`
+	Type         string     `json:"type"`
+	Status       string     `json:"status"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	OfflineSince *time.Time `json:"offline_since,omitempty"`
+	Age          float64    `json:"age_seconds,omitempty"` // of stale results
+	Error        string     `json:"error,omitempty"`
+}
+
+// sourceState remembers the last results read from a source, to serve while it is unreachable.
+type sourceState struct {
+	sync.Mutex
+	last         []*sample.Record
+	lastSuccess  time.Time
+	offlineSince time.Time
+	err          error
+}
+
+// succeeded returns the results that were not in the last results read, oldest first.
+// None on the first read.
+func (st *sourceState) succeeded(recs []*sample.Record) (added []*sample.Record) {
+	st.Lock()
+	defer st.Unlock()
+
+	if !st.lastSuccess.IsZero() {
+		seen := make(map[string]struct{}, len(st.last))
+		for _, r := range st.last {
+			seen[sampleKey(r)] = struct{}{}
+		}
+		for i := len(recs) - 1; i >= 0; i-- {
+			if _, ok := seen[sampleKey(recs[i])]; !ok {
+				added = append(added, recs[i])
+			}
+		}
+	}
+
+	st.last, st.lastSuccess = recs, time.Now()
+	st.offlineSince, st.err = time.Time{}, nil
+	return
+}
+
+// failed returns the last results read, and whether the source was ever read.
+func (st *sourceState) failed(err error) ([]*sample.Record, bool) {
+	st.Lock()
+	defer st.Unlock()
+
+	if st.offlineSince.IsZero() {
+		st.offlineSince = time.Now()
+	}
+	st.err = err
+	return st.last, !st.lastSuccess.IsZero()
+}
+
+func (st *sourceState) status(s *source) sourceStatus {
+	st.Lock()
+	defer st.Unlock()
+
+	ss := sourceStatus{Spectro: s.Spectro(), Type: s.conf.Type, Status: sourceOK}
+	if !st.lastSuccess.IsZero() {
+		lastSuccess := st.lastSuccess
+		ss.LastSuccess = &lastSuccess
+	}
+	if st.err == nil {
+		return ss
+	}
+
+	offlineSince := st.offlineSince
+	ss.OfflineSince, ss.Error = &offlineSince, st.err.Error()
+	if st.lastSuccess.IsZero() {
+		ss.Status = sourceOffline
+	} else {
+		ss.Status = sourceStale
+		ss.Age = time.Since(st.lastSuccess).Truncate(time.Second).Seconds()
+	}
+	return ss
+}
+
+func (a *app) sourceStatuses() []sourceStatus {
+	statuses := make([]sourceStatus, len(a.sources))
+	for i := range a.sources {
+		statuses[i] = a.sources[i].state.status(&a.sources[i])
+	}
+	return statuses
+}
@@ -0,0 +1,45 @@
+package dashboard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/http"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestSourceState(t *testing.T) {
+	sc := &config.Source{Type: config.SourceRemote, SpectroNumber: 3}
+	s := &source{ResultSource: http.NewRemoteSource("localhost:1", 3, 0, nil), conf: sc, state: &sourceState{}}
+
+	if _, ok := s.state.failed(errors.New("unreachable")); ok {
+		t.Fatal("never read source should have no results")
+	}
+	if ss := s.state.status(s); ss.Status != sourceOffline || ss.OfflineSince == nil || ss.Spectro != 3 {
+		t.Fatalf("unexpected status: %+v", ss)
+	}
+
+	recs := []*sample.Record{{SampleName: "A1"}}
+	if added := s.state.succeeded(recs); len(added) != 0 {
+		t.Fatalf("first read should add nothing: %+v", added)
+	}
+	if ss := s.state.status(s); ss.Status != sourceOK || ss.LastSuccess == nil || ss.Error != "" {
+		t.Fatalf("unexpected status: %+v", ss)
+	}
+
+	last, ok := s.state.failed(errors.New("timeout"))
+	if !ok || len(last) != 1 || last[0].SampleName != "A1" {
+		t.Fatalf("unexpected last results: %+v %v", last, ok)
+	}
+	ss := s.state.status(s)
+	if ss.Status != sourceStale || ss.Error != "timeout" || ss.OfflineSince.Before(*ss.LastSuccess) {
+		t.Fatalf("unexpected status: %+v", ss)
+	}
+
+	// latest first
+	recs = []*sample.Record{{SampleName: "A3"}, {SampleName: "A2"}, {SampleName: "A1"}}
+	if added := s.state.succeeded(recs); len(added) != 2 || added[0].SampleName != "A2" || added[1].SampleName != "A3" {
+		t.Fatalf("unexpected added results: %+v", added)
+	}
+}
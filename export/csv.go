@@ -0,0 +1,75 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// CSV options.
+type CSV struct {
+	Delimiter        rune   // between fields
+	DecimalSeparator string // in numbers
+}
+
+// header of sample columns, followed by elements.
+var header = []string{"TimeStamp", "Sample Name", "Furnace", "Spectro", "Grade"}
+
+// NewCSV returns CSV options from config strings, with defaults for empty ones.
+func NewCSV(delimiter, decimalSeparator string) CSV {
+	c := CSV{Delimiter: ',', DecimalSeparator: "."}
+	if r, _ := utf8.DecodeRuneInString(delimiter); r != utf8.RuneError {
+		c.Delimiter = r
+	}
+	if decimalSeparator != "" {
+		c.DecimalSeparator = decimalSeparator
+	}
+	return c
+}
+
+// Write samples as CSV, with a column for each of the elements in order.
+func (c CSV) Write(w io.Writer, recs []*sample.Record, elements []string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = c.Delimiter
+
+	row := make([]string, 0, len(header)+len(elements))
+	row = append(append(row, header...), elements...)
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+
+	for _, r := range recs {
+		row = append(row[:0],
+			r.TimeStamp.Format("2006-01-02 15:04:05"),
+			r.SampleName,
+			r.Furnace,
+			strconv.Itoa(r.Spectro),
+			r.Grade,
+		)
+
+		for _, el := range elements {
+			v, ok := r.ResultsMap[el]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+
+			num := strconv.FormatFloat(v, 'f', -1, 64)
+			if c.DecimalSeparator != "." {
+				num = strings.Replace(num, ".", c.DecimalSeparator, 1)
+			}
+			row = append(row, num)
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,71 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+var testRecs = []*sample.Record{
+	{SampleName: `A"1`, Furnace: "F1", Spectro: 2, TimeStamp: time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local), ResultsMap: map[string]float64{"C": 3.25, "Si": 2}},
+	{SampleName: "B<1>", Furnace: "F2", Spectro: 3, Grade: "GG25", TimeStamp: time.Date(2023, 5, 1, 10, 30, 0, 0, time.Local), ResultsMap: map[string]float64{"Si": 1.5}},
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewCSV(";", ",").Write(&buf, testRecs, []string{"C", "Si"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "TimeStamp;Sample Name;Furnace;Spectro;Grade;C;Si\n" +
+		"2023-05-01 12:00:00;\"A\"\"1\";F1;2;;3,25;2\n" +
+		"2023-05-01 10:30:00;B<1>;F2;3;GG25;;1,5\n"
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteXLSX(&buf, testRecs, []string{"C", "Si"}); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		sheet = string(b)
+	}
+
+	for _, want := range []string{
+		`<c r="G1" t="inlineStr"><is><t>Si</t></is></c>`,
+		`<c r="A2" s="1"><v>45047.5</v></c>`,
+		`<t>B&lt;1&gt;</t>`,
+		`<c r="G3"><v>1.5</v></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet does not contain %s:\n%s", want, sheet)
+		}
+	}
+	if strings.Contains(sheet, `r="F3"`) {
+		t.Error("missing element result should have no cell")
+	}
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Minimal Office Open XML workbook with one sheet. Strings are inline, so no shared strings part is needed.
+var xlsxStaticParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Samples" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// cell style 1 is a date and time
+	{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+// excel serial dates count days from 1899-12-30
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// WriteXLSX writes samples as an Excel workbook, with a column for each of the elements in order.
+func WriteXLSX(w io.Writer, recs []*sample.Record, elements []string) error {
+	zw := zip.NewWriter(w)
+
+	for _, p := range xlsxStaticParts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, p.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+
+	sw := sheetWriter{w: bufio.NewWriter(f)}
+	sw.str(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	sw.startRow()
+	for _, h := range header {
+		sw.strCell(h)
+	}
+	for _, el := range elements {
+		sw.strCell(el)
+	}
+	sw.endRow()
+
+	for _, r := range recs {
+		sw.startRow()
+		sw.timeCell(r.TimeStamp)
+		sw.strCell(r.SampleName)
+		sw.strCell(r.Furnace)
+		sw.numCell(float64(r.Spectro))
+		sw.strCell(r.Grade)
+
+		for _, el := range elements {
+			if v, ok := r.ResultsMap[el]; ok {
+				sw.numCell(v)
+			} else {
+				sw.col++
+			}
+		}
+		sw.endRow()
+	}
+
+	sw.str(`</sheetData></worksheet>`)
+	if sw.err != nil {
+		return sw.err
+	}
+	if err = sw.w.Flush(); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+type sheetWriter struct {
+	w   *bufio.Writer
+	row int
+	col int
+	err error
+}
+
+func (sw *sheetWriter) str(s string) {
+	if sw.err == nil {
+		_, sw.err = sw.w.WriteString(s)
+	}
+}
+
+func (sw *sheetWriter) startRow() {
+	sw.row++
+	sw.col = 0
+	sw.str(`<row r="` + strconv.Itoa(sw.row) + `">`)
+}
+
+func (sw *sheetWriter) endRow() {
+	sw.str(`</row>`)
+}
+
+// ref of next cell, e.g. AB12
+func (sw *sheetWriter) ref() string {
+	col := ""
+	for c := sw.col; c >= 0; c = c/26 - 1 {
+		col = string(rune('A'+c%26)) + col
+	}
+	sw.col++
+	return col + strconv.Itoa(sw.row)
+}
+
+func (sw *sheetWriter) strCell(s string) {
+	sw.str(`<c r="` + sw.ref() + `" t="inlineStr"><is><t>`)
+	if sw.err == nil {
+		sw.err = xml.EscapeText(sw.w, []byte(s))
+	}
+	sw.str(`</t></is></c>`)
+}
+
+func (sw *sheetWriter) numCell(v float64) {
+	sw.str(`<c r="` + sw.ref() + `"><v>` + strconv.FormatFloat(v, 'g', -1, 64) + `</v></c>`)
+}
+
+func (sw *sheetWriter) timeCell(t time.Time) {
+	// excel has no time zones, so use wall clock time
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	days := wall.Sub(excelEpoch).Hours() / 24
+	sw.str(`<c r="` + sw.ref() + `" s="1"><v>` + strconv.FormatFloat(days, 'f', -1, 64) + `</v></c>`)
+}
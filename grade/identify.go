@@ -0,0 +1,90 @@
+package grade
+
+import (
+	"math"
+	"sort"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Identify returns up to n grades that best match the chemistry of a sample, best first.
+// The distance to a grade is the root of the sum of squares of each element's deviation
+// outside of its limits, relative to the width of the limits.
+// Elements the sample was not analysed for are not considered.
+func (s *Specs) Identify(r *sample.Record, n int) []sample.GradeMatch {
+	if len(s.grades) == 0 || len(r.ResultsMap) == 0 {
+		return nil
+	}
+
+	matches := make([]sample.GradeMatch, 0, len(s.grades))
+	for g, limits := range s.grades {
+		m := sample.GradeMatch{Grade: g}
+		sumSq, checked := 0.0, 0
+
+		for el, l := range limits {
+			v, ok := r.ResultsMap[el]
+			if !ok {
+				continue
+			}
+			checked++
+
+			if d := deviation(l, v); d > 0 {
+				sumSq += d * d
+				m.Deviations = append(m.Deviations, el)
+			}
+		}
+
+		if checked == 0 {
+			continue
+		}
+
+		m.Distance = math.Sqrt(sumSq)
+		sort.Strings(m.Deviations)
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		if len(matches[i].Deviations) != len(matches[j].Deviations) {
+			return len(matches[i].Deviations) < len(matches[j].Deviations)
+		}
+		return matches[i].Grade < matches[j].Grade
+	})
+
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches
+}
+
+// deviation of a value outside of its limits, relative to the width of the limits.
+// If only one limit is set, it is relative to that limit.
+func deviation(l config.ElementLimits, v float64) float64 {
+	var d float64
+	switch {
+	case l.Min != nil && v < *l.Min:
+		d = *l.Min - v
+	case l.Max != nil && v > *l.Max:
+		d = v - *l.Max
+	default:
+		return 0
+	}
+
+	var scale float64
+	switch {
+	case l.Min != nil && l.Max != nil && *l.Max > *l.Min:
+		scale = *l.Max - *l.Min
+	case l.Min != nil:
+		scale = math.Abs(*l.Min)
+	default:
+		scale = math.Abs(*l.Max)
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	return d / scale
+}
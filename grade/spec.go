@@ -0,0 +1,103 @@
+package grade
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Specs checks samples against the grade specifications in config.
+type Specs struct {
+	grades      map[string]map[string]config.ElementLimits
+	assignments []assignment
+}
+
+type assignment struct {
+	furnace    string
+	sampleName *regexp.Regexp // nil matches all
+	grade      string
+}
+
+func NewSpecs(conf *config.Config) (*Specs, error) {
+	s := &Specs{
+		grades:      conf.Grades,
+		assignments: make([]assignment, len(conf.GradeAssignments)),
+	}
+
+	for i, ga := range conf.GradeAssignments {
+		s.assignments[i] = assignment{furnace: ga.Furnace, grade: ga.Grade}
+		if ga.SampleName != "" {
+			re, err := regexp.Compile(ga.SampleName)
+			if err != nil {
+				return nil, err
+			}
+			s.assignments[i].sampleName = re
+		}
+	}
+
+	return s, nil
+}
+
+// GradeOf returns the grade assigned to a sample, or "" if none.
+func (s *Specs) GradeOf(r *sample.Record) string {
+	for _, a := range s.assignments {
+		if a.furnace != "" && !strings.EqualFold(a.furnace, r.Furnace) {
+			continue
+		}
+		if a.sampleName != nil && !a.sampleName.MatchString(r.SampleName) {
+			continue
+		}
+		return a.grade
+	}
+	return ""
+}
+
+// Limits returns the element limits of a grade, or nil if unknown.
+func (s *Specs) Limits(grade string) map[string]config.ElementLimits {
+	return s.grades[grade]
+}
+
+// Annotate sets the grade of a sample and the status of each of its element results.
+func (s *Specs) Annotate(r *sample.Record) {
+	r.Grade = s.GradeOf(r)
+	limits := s.grades[r.Grade]
+
+	for i := range r.Results {
+		er := &r.Results[i]
+		er.Status = ""
+		if l, ok := limits[er.Element]; ok {
+			er.Status = Status(l, er.Value)
+		}
+	}
+}
+
+// SampleStatus is the worst status of a sample's annotated element results, or empty if it has no grade.
+func SampleStatus(r *sample.Record) string {
+	if r.Grade == "" {
+		return ""
+	}
+
+	status := sample.StatusOK
+	for _, er := range r.Results {
+		switch er.Status {
+		case sample.StatusOutOfSpec:
+			return sample.StatusOutOfSpec
+		case sample.StatusWarning:
+			status = sample.StatusWarning
+		}
+	}
+	return status
+}
+
+// Status of an element value against its limits.
+func Status(l config.ElementLimits, v float64) string {
+	if (l.Min != nil && v < *l.Min) || (l.Max != nil && v > *l.Max) {
+		return sample.StatusOutOfSpec
+	}
+	if (l.WarnMin != nil && v < *l.WarnMin) || (l.WarnMax != nil && v > *l.WarnMax) {
+		return sample.StatusWarning
+	}
+	return sample.StatusOK
+}
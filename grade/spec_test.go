@@ -0,0 +1,98 @@
+package grade
+
+import (
+	"testing"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestAnnotate(t *testing.T) {
+	conf := &config.Config{
+		Grades: map[string]map[string]config.ElementLimits{
+			"GG25": {
+				"C":  {Min: f(3.2), Max: f(3.5), WarnMin: f(3.25), WarnMax: f(3.45)},
+				"Si": {Max: f(2.4)},
+			},
+			"GGG40": {"Mg": {Min: f(0.03)}},
+		},
+		GradeAssignments: []config.GradeAssignment{
+			{SampleName: "^GGG", Grade: "GGG40"},
+			{Furnace: "F1", Grade: "GG25"},
+		},
+	}
+
+	specs, err := NewSpecs(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sample.Record{SampleName: "A1", Furnace: "f1", Results: []sample.ElementResult{
+		{Element: "C", Value: 3.3}, {Element: "Si", Value: 2.5}, {Element: "Mn", Value: 0.5}, {Element: "C", Value: 3.22}, {},
+	}}
+	specs.Annotate(r)
+
+	if r.Grade != "GG25" {
+		t.Fatalf("expected grade GG25, got %q", r.Grade)
+	}
+	want := []string{sample.StatusOK, sample.StatusOutOfSpec, "", sample.StatusWarning, ""}
+	for i, er := range r.Results {
+		if er.Status != want[i] {
+			t.Errorf("result %d: got status %q, want %q", i, er.Status, want[i])
+		}
+	}
+	if s := SampleStatus(r); s != sample.StatusOutOfSpec {
+		t.Errorf("got sample status %q, want %q", s, sample.StatusOutOfSpec)
+	}
+
+	r = &sample.Record{SampleName: "GGG1", Furnace: "F1", Results: []sample.ElementResult{{Element: "Mg", Value: 0.02}}}
+	specs.Annotate(r)
+	if r.Grade != "GGG40" || r.Results[0].Status != sample.StatusOutOfSpec {
+		t.Fatalf("unexpected annotation: %+v", r)
+	}
+
+	r = &sample.Record{SampleName: "X", Furnace: "F2", Results: []sample.ElementResult{{Element: "C", Value: 1}}}
+	specs.Annotate(r)
+	if r.Grade != "" || r.Results[0].Status != "" || SampleStatus(r) != "" {
+		t.Fatalf("unexpected annotation without grade: %+v", r)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	conf := &config.Config{
+		Grades: map[string]map[string]config.ElementLimits{
+			"GG20":  {"C": {Min: f(3.3), Max: f(3.6)}, "Si": {Min: f(2.0), Max: f(2.5)}},
+			"GG25":  {"C": {Min: f(3.2), Max: f(3.5)}, "Si": {Min: f(1.8), Max: f(2.4)}},
+			"GGG40": {"C": {Min: f(3.4), Max: f(3.8)}, "Mg": {Min: f(0.03), Max: f(0.06)}},
+			"Steel": {"Cr": {Min: f(12)}},
+		},
+	}
+
+	specs, err := NewSpecs(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sample.Record{ResultsMap: map[string]float64{"C": 3.25, "Si": 2.45, "Mg": 0.001}}
+	m := specs.Identify(r, 3)
+
+	if len(m) != 3 {
+		t.Fatalf("expected 3 matches, got %+v", m)
+	}
+	if m[0].Grade != "GG25" || m[0].Distance <= 0 || len(m[0].Deviations) != 1 || m[0].Deviations[0] != "Si" {
+		t.Fatalf("unexpected best match: %+v", m[0])
+	}
+	if m[1].Grade != "GG20" || len(m[1].Deviations) != 1 || m[1].Deviations[0] != "C" {
+		t.Fatalf("unexpected second match: %+v", m[1])
+	}
+	if m[2].Grade != "GGG40" || len(m[2].Deviations) != 2 {
+		t.Fatalf("unexpected third match: %+v", m[2])
+	}
+
+	r = &sample.Record{ResultsMap: map[string]float64{"C": 3.55, "Si": 2.2}}
+	if m = specs.Identify(r, 1); len(m) != 1 || m[0].Grade != "GG20" || m[0].Distance != 0 {
+		t.Fatalf("expected exact match on GG20, got %+v", m)
+	}
+}
@@ -0,0 +1,44 @@
+package health
+
+import "time"
+
+// Component statuses.
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning" // working, but needs attention
+	StatusDown    = "down"
+)
+
+// Report of the health of the service and each of its dependencies.
+type Report struct {
+	Status     string      `json:"status"` // worst of components
+	Ready      bool        `json:"ready"`  // able to serve results
+	CheckedAt  time.Time   `json:"checked_at"`
+	Components []Component `json:"components"`
+}
+
+type Component struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Add a component, updating the overall status.
+func (r *Report) Add(c Component) {
+	r.Components = append(r.Components, c)
+	if rank(c.Status) > rank(r.Status) {
+		r.Status = c.Status
+	}
+}
+
+func rank(status string) int {
+	switch status {
+	case StatusOK:
+		return 1
+	case StatusWarning:
+		return 2
+	case StatusDown:
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package heat
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/grade"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Heat is one melt of a furnace, from its first sample up to it being tapped.
+type Heat struct {
+	Furnace     string           `json:"furnace"`
+	Heat        string           `json:"heat,omitempty"` // parsed from sample names, if in them
+	FirstSample time.Time        `json:"first_sample"`
+	LastSample  time.Time        `json:"last_sample"`
+	Samples     []*sample.Record `json:"samples"`      // in time order
+	BathSamples int              `json:"bath_samples"` // bath and unclassified samples
+	Corrections int              `json:"corrections"`  // samples out of spec that were followed by another sample before tap
+	Tap         *sample.Record   `json:"tap"`          // last tap sample, with the final chemistry. Nil while melting.
+}
+
+// Group groups samples, in time order, into the heats of their furnaces. Samples of a heat have the same
+// heat number, or without heat numbers, follow each other within gap and do not follow a tap sample.
+// Samples without furnace and check samples are not part of heats. Heats are returned latest first.
+// Samples should be annotated with their grade status, for corrections to be counted.
+func Group(recs []*sample.Record, gap time.Duration) []*Heat {
+	var heats []*Heat
+	current := make(map[string]*Heat) // by upper case furnace
+
+	for _, r := range recs {
+		if r.Furnace == "" || r.Type == sample.TypeCheck {
+			continue
+		}
+
+		f := strings.ToUpper(r.Furnace)
+		h := current[f]
+		if h == nil || !h.continuedBy(r, gap) {
+			h = &Heat{Furnace: r.Furnace, Heat: r.Heat, FirstSample: r.TimeStamp}
+			current[f] = h
+			heats = append(heats, h)
+		}
+		h.add(r)
+	}
+
+	for _, h := range heats {
+		h.countCorrections()
+	}
+
+	sort.SliceStable(heats, func(i, j int) bool {
+		return heats[i].LastSample.After(heats[j].LastSample)
+	})
+	return heats
+}
+
+// continuedBy is true if the sample is of the heat.
+func (h *Heat) continuedBy(r *sample.Record, gap time.Duration) bool {
+	if h.Heat != "" && r.Heat != "" {
+		return strings.EqualFold(h.Heat, r.Heat)
+	}
+	if r.TimeStamp.Sub(h.LastSample) > gap {
+		return false
+	}
+	// tapped heats are only continued by repeated tap samples
+	return h.Tap == nil || r.Type == sample.TypeTap
+}
+
+func (h *Heat) add(r *sample.Record) {
+	h.Samples = append(h.Samples, r)
+	h.LastSample = r.TimeStamp
+	if h.Heat == "" {
+		h.Heat = r.Heat
+	}
+
+	switch r.Type {
+	case sample.TypeTap:
+		h.Tap = r
+	case sample.TypeBath, "":
+		h.BathSamples++
+	}
+}
+
+func (h *Heat) countCorrections() {
+	for i, r := range h.Samples[:len(h.Samples)-1] {
+		if r.Type != sample.TypeTap && grade.SampleStatus(r) == sample.StatusOutOfSpec && h.Samples[i+1].Type != sample.TypeTap {
+			h.Corrections++
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package heat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestGroup(t *testing.T) {
+	t0 := time.Date(2023, 5, 1, 8, 0, 0, 0, time.Local)
+	rec := func(min int, name, furnace, typ, heat string, outOfSpec bool) *sample.Record {
+		r := &sample.Record{SampleName: name, Furnace: furnace, Type: typ, Heat: heat, TimeStamp: t0.Add(time.Duration(min) * time.Minute)}
+		if outOfSpec {
+			r.Grade = "GG25"
+			r.Results = []sample.ElementResult{{Element: "C", Status: sample.StatusOutOfSpec}}
+		}
+		return r
+	}
+
+	heats := Group([]*sample.Record{
+		// F1 without heat numbers: corrected twice, tapped, then a new melt after the tap
+		rec(0, "1", "F1", "bath", "", true),
+		rec(10, "2", "F1", "bath", "", true),
+		rec(15, "CHK", "F1", "check", "", false),
+		rec(20, "3", "F1", "bath", "", false),
+		rec(30, "3T", "F1", "tap", "", false),
+		rec(35, "3T2", "f1", "tap", "", false),
+		rec(40, "4", "F1", "bath", "", false),
+		// F2 with heat numbers, interleaved and with a long gap
+		rec(5, "100-1", "F2", "bath", "100", true),
+		rec(25, "100-2T", "F2", "tap", "100", true),
+		rec(300, "101-1", "F2", "", "101", false),
+		// after a gap
+		rec(200, "5", "F1", "bath", "", false),
+		rec(210, "", "", "bath", "", false),
+	}, time.Hour)
+
+	if len(heats) != 5 {
+		t.Fatalf("expected 5 heats, got %d", len(heats))
+	}
+	want := []struct {
+		furnace, heat      string
+		samples, bath, cor int
+		tap                string
+	}{
+		{"F2", "101", 1, 1, 0, ""},
+		{"F1", "", 1, 1, 0, ""},
+		{"F1", "", 1, 1, 0, ""},
+		{"F1", "", 5, 3, 2, "3T2"},
+		{"F2", "100", 2, 1, 0, "100-2T"},
+	}
+	for i, w := range want {
+		h := heats[i]
+		if h.Furnace != w.furnace || h.Heat != w.heat || len(h.Samples) != w.samples || h.BathSamples != w.bath || h.Corrections != w.cor {
+			t.Errorf("heat %d: unexpected %+v", i, h)
+		}
+		if (h.Tap == nil) != (w.tap == "") || (h.Tap != nil && h.Tap.SampleName != w.tap) {
+			t.Errorf("heat %d: unexpected tap sample %+v", i, h.Tap)
+		}
+	}
+
+	if h := heats[3]; !h.FirstSample.Equal(t0) || !h.LastSample.Equal(t0.Add(time.Minute*35)) {
+		t.Errorf("unexpected heat times: %v - %v", h.FirstSample, h.LastSample)
+	}
+}
@@ -0,0 +1,109 @@
+package history
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query filters samples in the store. Zero fields do not filter.
+type Query struct {
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	Furnace    string
+	Spectro    int
+	NamePrefix string // case insensitive
+	Method     string // case insensitive
+	Type       string // sample type
+	Heat       string // case insensitive
+	Shift      string // case insensitive
+
+	Limit  int    // page size
+	Cursor string // NextCursor of previous page
+}
+
+// Page is one page of query results, latest sample first.
+type Page struct {
+	Samples    []*sample.Record `json:"samples"`
+	NextCursor string           `json:"next_cursor,omitempty"` // empty on last page
+}
+
+// Query returns samples matching q, latest first.
+func (s *Store) Query(q Query) (*Page, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	} else if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+
+	lo, hi := timeKey(q.From), upperTimeKey(q.To)
+	if q.Cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil || len(after) < 12 {
+			return nil, ErrInvalidCursor
+		}
+		if bytes.Compare(after, hi) < 0 {
+			hi = after
+		}
+	}
+
+	// use most selective index available
+	bucket, prefix := samplesBucket, []byte(nil)
+	if q.Furnace != "" {
+		bucket, prefix = byFurnaceBucket, furnacePrefix(q.Furnace)
+	} else if q.Spectro != 0 {
+		bucket, prefix = bySpectroBucket, spectroPrefix(q.Spectro)
+	}
+
+	namePrefix := strings.ToUpper(q.NamePrefix)
+	page := &Page{Samples: make([]*sample.Record, 0, q.Limit)}
+	more := false
+
+	err := s.scan(bucket, prefix, lo, hi, true, func(r *sample.Record) bool {
+		if q.Spectro != 0 && r.Spectro != q.Spectro {
+			return true
+		}
+		if namePrefix != "" && !strings.HasPrefix(strings.ToUpper(r.SampleName), namePrefix) {
+			return true
+		}
+		if q.Method != "" && !strings.EqualFold(r.Method, q.Method) {
+			return true
+		}
+		if q.Type != "" && r.Type != q.Type {
+			return true
+		}
+		if q.Heat != "" && !strings.EqualFold(r.Heat, q.Heat) {
+			return true
+		}
+		if q.Shift != "" && !strings.EqualFold(r.Shift, q.Shift) {
+			return true
+		}
+
+		if len(page.Samples) == q.Limit {
+			more = true
+			return false
+		}
+		page.Samples = append(page.Samples, r)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if more {
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(recordKey(page.Samples[len(page.Samples)-1]))
+	}
+
+	return page, nil
+}
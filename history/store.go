@@ -0,0 +1,282 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	samplesBucket   = []byte("samples")    // key -> stored record, ordered by time
+	byFurnaceBucket = []byte("by_furnace") // upper case furnace, 0, key
+	bySpectroBucket = []byte("by_spectro") // spectro, key
+	byNameBucket    = []byte("by_name")    // upper case sample name, 0, key
+)
+
+// Store is a persistent history of all samples ever ingested, kept in an embedded database file.
+// A sample is identified by its measurement time, spectro and sample name.
+type Store struct {
+	db *bolt.DB
+}
+
+type storedRecord struct {
+	ID         string             `json:"id"`
+	SampleName string             `json:"sample_name"`
+	Furnace    string             `json:"furnace"`
+	Type       string             `json:"type,omitempty"`
+	Heat       string             `json:"heat,omitempty"`
+	Sequence   int                `json:"sequence,omitempty"`
+	Shift      string             `json:"shift,omitempty"`
+	TimeStamp  time.Time          `json:"time_stamp"`
+	Spectro    int                `json:"spectro"`
+	Method     string             `json:"method,omitempty"`
+	Operator   string             `json:"operator,omitempty"`
+	Results    map[string]float64 `json:"results"`
+}
+
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, fmt.Errorf("failed opening history store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{samplesBucket, byFurnaceBucket, bySpectroBucket, byNameBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed creating history store buckets: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("failed closing history store: %w", err)
+	}
+	return nil
+}
+
+// New returns the samples not yet in the store.
+func (s *Store) New(recs []*sample.Record) ([]*sample.Record, error) {
+	var newRecs []*sample.Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(samplesBucket)
+		for _, r := range recs {
+			if b.Get(recordKey(r)) == nil {
+				newRecs = append(newRecs, r)
+			}
+		}
+		return nil
+	})
+	return newRecs, err
+}
+
+// Add saves samples not yet in the store, and returns those that were new.
+// A sample added concurrently is only returned to one caller.
+func (s *Store) Add(recs []*sample.Record) ([]*sample.Record, error) {
+	// avoid a write transaction when nothing is new
+	candidates, err := s.New(recs)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+
+	var newRecs []*sample.Record
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		samples := tx.Bucket(samplesBucket)
+		byFurnace := tx.Bucket(byFurnaceBucket)
+		bySpectro := tx.Bucket(bySpectroBucket)
+		byName := tx.Bucket(byNameBucket)
+
+		for _, r := range candidates {
+			k := recordKey(r)
+			if samples.Get(k) != nil {
+				continue
+			}
+
+			v, err := json.Marshal(&storedRecord{
+				ID:         r.ID,
+				SampleName: r.SampleName,
+				Furnace:    r.Furnace,
+				Type:       r.Type,
+				Heat:       r.Heat,
+				Sequence:   r.Sequence,
+				Shift:      r.Shift,
+				TimeStamp:  r.TimeStamp,
+				Spectro:    r.Spectro,
+				Method:     r.Method,
+				Operator:   r.Operator,
+				Results:    r.ResultsMap,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err = samples.Put(k, v); err != nil {
+				return err
+			}
+			if err = byFurnace.Put(indexKey(furnacePrefix(r.Furnace), k), nil); err != nil {
+				return err
+			}
+			if err = bySpectro.Put(indexKey(spectroPrefix(r.Spectro), k), nil); err != nil {
+				return err
+			}
+			if err = byName.Put(indexKey([]byte(strings.ToUpper(r.SampleName)+"\x00"), k), nil); err != nil {
+				return err
+			}
+			newRecs = append(newRecs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed adding samples to history store: %w", err)
+	}
+
+	return newRecs, nil
+}
+
+// Between calls fn with samples from, up to but excluding, to, in time order.
+// Zero from or to is unbounded. Iteration stops when fn returns false.
+func (s *Store) Between(from, to time.Time, fn func(r *sample.Record) bool) error {
+	return s.scan(samplesBucket, nil, timeKey(from), upperTimeKey(to), false, fn)
+}
+
+// Furnace calls fn with samples of a furnace in time order, like Between.
+func (s *Store) Furnace(furnace string, from, to time.Time, fn func(r *sample.Record) bool) error {
+	return s.scan(byFurnaceBucket, furnacePrefix(furnace), timeKey(from), upperTimeKey(to), false, fn)
+}
+
+// Spectro calls fn with samples from a spectro machine in time order, like Between.
+func (s *Store) Spectro(spectro int, from, to time.Time, fn func(r *sample.Record) bool) error {
+	return s.scan(bySpectroBucket, spectroPrefix(spectro), timeKey(from), upperTimeKey(to), false, fn)
+}
+
+// SampleName calls fn with samples with the given name (case insensitive) in time order.
+func (s *Store) SampleName(name string, fn func(r *sample.Record) bool) error {
+	return s.scan(byNameBucket, []byte(strings.ToUpper(name)+"\x00"), timeKey(time.Time{}), upperTimeKey(time.Time{}), false, fn)
+}
+
+// scan calls fn with the samples of an index bucket that have keys prefix+k, where lo <= k < hi.
+// The samples bucket itself has no prefix. Samples are in time order, or latest first if reverse.
+func (s *Store) scan(bucket, prefix, lo, hi []byte, reverse bool, fn func(r *sample.Record) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		samples := tx.Bucket(samplesBucket)
+		c := tx.Bucket(bucket).Cursor()
+		start, end := indexKey(prefix, lo), indexKey(prefix, hi)
+
+		var k, v []byte
+		if reverse {
+			if k, v = c.Seek(end); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(start)
+		}
+
+		for ; k != nil; k, v = next(c, reverse) {
+			if !bytes.HasPrefix(k, prefix) || bytes.Compare(k, start) < 0 || bytes.Compare(k, end) >= 0 {
+				break
+			}
+
+			if !bytes.Equal(bucket, samplesBucket) {
+				if v = samples.Get(k[len(prefix):]); v == nil {
+					continue
+				}
+			}
+
+			r, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			if !fn(r) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func next(c *bolt.Cursor, reverse bool) ([]byte, []byte) {
+	if reverse {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+func decodeRecord(v []byte) (*sample.Record, error) {
+	var sr storedRecord
+	if err := json.Unmarshal(v, &sr); err != nil {
+		return nil, fmt.Errorf("failed decoding sample from history store: %w", err)
+	}
+
+	return &sample.Record{
+		ID:         sr.ID,
+		SampleName: sr.SampleName,
+		Furnace:    sr.Furnace,
+		Type:       sr.Type,
+		Heat:       sr.Heat,
+		Sequence:   sr.Sequence,
+		Shift:      sr.Shift,
+		TimeStamp:  sr.TimeStamp.Local(),
+		Spectro:    sr.Spectro,
+		Method:     sr.Method,
+		Operator:   sr.Operator,
+		ResultsMap: sr.Results,
+	}, nil
+}
+
+// recordKey is the measurement time, spectro and sample name. Keys sort by time.
+func recordKey(r *sample.Record) []byte {
+	k := make([]byte, 12, 12+len(r.SampleName))
+	binary.BigEndian.PutUint64(k, uint64(r.TimeStamp.UnixNano()))
+	binary.BigEndian.PutUint32(k[8:], uint32(r.Spectro))
+	return append(k, r.SampleName...)
+}
+
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	}
+	return k
+}
+
+// upperTimeKey is like timeKey, but is past all keys for zero t.
+func upperTimeKey(t time.Time) []byte {
+	if t.IsZero() {
+		return bytes.Repeat([]byte{0xFF}, 9)
+	}
+	return timeKey(t)
+}
+
+func indexKey(prefix, key []byte) []byte {
+	k := make([]byte, 0, len(prefix)+len(key))
+	return append(append(k, prefix...), key...)
+}
+
+func furnacePrefix(furnace string) []byte {
+	return []byte(strings.ToUpper(furnace) + "\x00")
+}
+
+func spectroPrefix(spectro int) []byte {
+	p := make([]byte, 4)
+	binary.BigEndian.PutUint32(p, uint32(spectro))
+	return p
+}
@@ -0,0 +1,198 @@
+package history
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func collect(t *testing.T, scan func(fn func(r *sample.Record) bool) error) []string {
+	t.Helper()
+	var names []string
+	if err := scan(func(r *sample.Record) bool {
+		names = append(names, r.SampleName)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Date(2023, 5, 1, 10, 0, 0, 0, time.Local)
+	recs := []*sample.Record{
+		{SampleName: "A2", Furnace: "F1", Spectro: 2, TimeStamp: t0.Add(time.Hour * 2), ResultsMap: map[string]float64{"C": 3.3}},
+		{SampleName: "B1", Furnace: "F2", Spectro: 3, TimeStamp: t0.Add(time.Hour)},
+		{SampleName: "A1", Furnace: "f1", Spectro: 2, TimeStamp: t0},
+	}
+
+	if added, err := s.Add(recs); err != nil || len(added) != 3 {
+		t.Fatalf("expected 3 new samples, got %d: %v", len(added), err)
+	}
+	if added, err := s.Add(recs[:1]); err != nil || len(added) != 0 {
+		t.Fatalf("expected sample to already exist, got %d: %v", len(added), err)
+	}
+
+	// reopen to check persistence
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	check := func(what string, got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v, want %v", what, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%s: got %v, want %v", what, got, want)
+			}
+		}
+	}
+
+	check("all", collect(t, func(fn func(r *sample.Record) bool) error {
+		return s.Between(time.Time{}, time.Time{}, fn)
+	}), "A1", "B1", "A2")
+
+	check("between", collect(t, func(fn func(r *sample.Record) bool) error {
+		return s.Between(t0.Add(time.Minute), t0.Add(time.Hour*2), fn)
+	}), "B1")
+
+	check("furnace", collect(t, func(fn func(r *sample.Record) bool) error {
+		return s.Furnace("F1", time.Time{}, time.Time{}, fn)
+	}), "A1", "A2")
+
+	check("furnace from", collect(t, func(fn func(r *sample.Record) bool) error {
+		return s.Furnace("f1", t0.Add(time.Minute), time.Time{}, fn)
+	}), "A2")
+
+	check("spectro", collect(t, func(fn func(r *sample.Record) bool) error {
+		return s.Spectro(3, time.Time{}, time.Time{}, fn)
+	}), "B1")
+
+	check("name", collect(t, func(fn func(r *sample.Record) bool) error {
+		return s.SampleName("a2", fn)
+	}), "A2")
+
+	s.SampleName("A2", func(r *sample.Record) bool {
+		if r.ResultsMap["C"] != 3.3 || !r.TimeStamp.Equal(recs[0].TimeStamp) {
+			t.Fatalf("unexpected stored sample: %+v", r)
+		}
+		return false
+	})
+}
+
+func TestAddConcurrent(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	t0 := time.Date(2023, 5, 1, 10, 0, 0, 0, time.Local)
+	var recs []*sample.Record
+	for i := 0; i < 20; i++ {
+		recs = append(recs, &sample.Record{SampleName: "A", Spectro: 1, TimeStamp: t0.Add(time.Minute * time.Duration(i))})
+	}
+
+	var added int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newRecs, err := s.Add(recs)
+			if err != nil {
+				t.Error(err)
+			}
+			atomic.AddInt32(&added, int32(len(newRecs)))
+		}()
+	}
+	wg.Wait()
+
+	if added != int32(len(recs)) {
+		t.Fatalf("expected %d samples added once, got %d", len(recs), added)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	t0 := time.Date(2023, 5, 1, 10, 0, 0, 0, time.Local)
+	recs := make([]*sample.Record, 0, 10)
+	for i := 0; i < 10; i++ {
+		r := &sample.Record{SampleName: "A" + string(rune('0'+i)), Furnace: "F1", Spectro: 2, TimeStamp: t0.Add(time.Duration(i) * time.Minute)}
+		if i%2 == 1 {
+			r.SampleName, r.Furnace, r.Spectro, r.Method = "B"+string(rune('0'+i)), "F2", 3, "Fe-10"
+		}
+		if i%3 == 0 {
+			r.Type = sample.TypeTap
+		}
+		r.Heat = "H" + string(rune('1'+i/5))
+		recs = append(recs, r)
+	}
+	if _, err = s.Add(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(p *Page) string {
+		var n string
+		for _, r := range p.Samples {
+			n += r.SampleName + " "
+		}
+		return n
+	}
+
+	tests := []struct {
+		q    Query
+		want []string // pages
+	}{
+		{Query{Limit: 4}, []string{"B9 A8 B7 A6 ", "B5 A4 B3 A2 ", "B1 A0 "}},
+		{Query{Furnace: "f1", Limit: 3}, []string{"A8 A6 A4 ", "A2 A0 "}},
+		{Query{Spectro: 3, From: t0.Add(time.Minute * 3), To: t0.Add(time.Minute * 9)}, []string{"B7 B5 B3 "}},
+		{Query{NamePrefix: "b", Limit: 2, To: t0.Add(time.Minute * 5)}, []string{"B3 B1 "}},
+		{Query{Method: "FE-10", Furnace: "F2", Limit: 5}, []string{"B9 B7 B5 B3 B1 "}},
+		{Query{Spectro: 2, Furnace: "F2"}, []string{""}},
+		{Query{Type: sample.TypeTap, Limit: 3}, []string{"B9 A6 B3 ", "A0 "}},
+		{Query{Heat: "h1", Furnace: "F1"}, []string{"A4 A2 A0 "}},
+	}
+
+	for i, tc := range tests {
+		q := tc.q
+		for j, want := range tc.want {
+			p, err := s.Query(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(p); got != want {
+				t.Fatalf("test %d page %d: got %q, want %q", i, j, got, want)
+			}
+			if (p.NextCursor == "") != (j == len(tc.want)-1) {
+				t.Fatalf("test %d page %d: unexpected cursor %q", i, j, p.NextCursor)
+			}
+			q.Cursor = p.NextCursor
+		}
+	}
+
+	if _, err = s.Query(Query{Cursor: "!"}); err != ErrInvalidCursor {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+}
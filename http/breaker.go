@@ -0,0 +1,71 @@
+package http
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Remote machines are not called for breakerCoolDown after breakerThreshold calls in a row failed.
+const (
+	breakerThreshold = 3
+	breakerCoolDown  = time.Second * 30
+)
+
+// ErrCircuitOpen is returned for calls to a remote machine that is not called while it cools down.
+type ErrCircuitOpen struct {
+	Until time.Time
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("remote unavailable, not called again until %s", e.Until.Format("15:04:05"))
+}
+
+// breaker stops calls to a failing remote machine for a cool-down period. After it, one call is let
+// through to try the machine again. The breaker closes when it succeeds, or opens again when it fails.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int // in a row
+	openUntil time.Time
+	trial     bool // call in progress after cool-down
+
+	onChange func(open bool)
+}
+
+// allow returns an error if the call should not be made. Otherwise, done must be called with its result.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold {
+		return nil
+	}
+	if now := time.Now(); now.Before(b.openUntil) || b.trial {
+		return &ErrCircuitOpen{Until: b.openUntil}
+	}
+
+	b.trial = true
+	return nil
+}
+
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	wasOpen := b.failures >= breakerThreshold
+	b.trial = false
+
+	if err == nil {
+		b.failures = 0
+	} else {
+		b.failures++
+		if b.failures >= breakerThreshold {
+			b.openUntil = time.Now().Add(breakerCoolDown)
+		}
+	}
+
+	open := b.failures >= breakerThreshold
+	b.mu.Unlock()
+
+	if open != wasOpen && b.onChange != nil {
+		b.onChange(open)
+	}
+}
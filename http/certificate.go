@@ -0,0 +1,65 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/RoanBrand/SpectroDashboard/certificate"
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+var certificateFunc func(spectro int, id, heat string) (*certificate.Certificate, error)
+
+// SetupCertificate serves certificates of analysis on /certificate.
+func SetupCertificate(certificateGetter func(int, string, string) (*certificate.Certificate, error)) {
+	http.HandleFunc("/certificate", certificateEndpoint)
+	certificateFunc = certificateGetter
+}
+
+// e.g. /certificate?s=2&id=1234 or /certificate?heat=H123&format=pdf
+func certificateEndpoint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	heat := q.Get("heat")
+
+	var spectro int
+	if heat == "" {
+		var err error
+		if spectro, err = strconv.Atoi(q.Get("s")); err != nil {
+			http.Error(w, "provide either heat, or spectro number and sample id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	cert, err := certificateFunc(spectro, q.Get("id"), heat)
+	if err != nil {
+		if errors.Is(err, sample.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		errMsg := "Error creating certificate: " + err.Error()
+		log.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if q.Get("format") == "pdf" {
+		err = certificate.WritePDF(&buf, cert)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `inline; filename="certificate.pdf"`)
+	} else {
+		err = certificate.WriteHTML(&buf, cert)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	buf.WriteTo(w)
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/RoanBrand/SpectroDashboard/correction"
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+var correctionFunc func(furnace, grade string, bathWeight float64) (interface{}, error)
+
+// SetupCorrection serves furnace correction calculations on /correction.
+func SetupCorrection(correctionGetter func(string, string, float64) (interface{}, error)) {
+	http.HandleFunc("/correction", correctionEndpoint)
+	correctionFunc = correctionGetter
+}
+
+// e.g. /correction?f=F1&grade=GG25&weight=6000. Grade and weight (kg) are optional.
+func correctionEndpoint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	furnace := q.Get("f")
+	if furnace == "" {
+		http.Error(w, "no furnace provided", http.StatusBadRequest)
+		return
+	}
+
+	var weight float64
+	if ws := q.Get("weight"); ws != "" {
+		var err error
+		if weight, err = strconv.ParseFloat(ws, 64); err != nil || weight <= 0 {
+			http.Error(w, "invalid bath weight: "+ws, http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := correctionFunc(furnace, q.Get("grade"), weight)
+	if err != nil {
+		switch {
+		case errors.Is(err, sample.ErrNotFound):
+			http.Error(w, "no sample found for furnace "+furnace, http.StatusNotFound)
+		case errors.Is(err, correction.ErrInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			errMsg := "Error calculating correction: " + err.Error()
+			log.Println(errMsg)
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
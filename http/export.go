@@ -0,0 +1,74 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/export"
+	"github.com/RoanBrand/SpectroDashboard/history"
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+var exportFunc func(q history.Query) ([]*sample.Record, error)
+var exportElements []string
+var exportCSV export.CSV
+
+// SetupExport serves filtered samples as spreadsheets on /export/csv and /export/xlsx,
+// with the same filters as /samples. Elements are columns in the order given.
+func SetupExport(samplesGetter func(history.Query) ([]*sample.Record, error), elements []string, csvOpts export.CSV) {
+	http.HandleFunc("/export/csv", exportEndpoint)
+	http.HandleFunc("/export/xlsx", exportEndpoint)
+	exportFunc = samplesGetter
+	exportElements = elements
+	exportCSV = csvOpts
+}
+
+// e.g. /export/csv?from=2023-05-01&f=F1&delimiter=%3B&decimal=,
+func exportEndpoint(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	q, err := parseSampleQuery(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recs, err := exportFunc(q)
+	if err != nil {
+		if errors.Is(err, history.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		errMsg := "Error querying samples: " + err.Error()
+		log.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	fileName := "samples_" + time.Now().Format("20060102_150405")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.URL.Path == "/export/xlsx" {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`.xlsx"`)
+		err = export.WriteXLSX(w, recs, exportElements)
+	} else {
+		opts := exportCSV
+		if d := v.Get("delimiter"); d != "" {
+			opts = export.NewCSV(d, opts.DecimalSeparator)
+		}
+		if d := v.Get("decimal"); d != "" {
+			opts.DecimalSeparator = d
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`.csv"`)
+		err = opts.Write(w, recs, exportElements)
+	}
+
+	if err != nil {
+		log.Println("Error writing export:", err)
+	}
+}
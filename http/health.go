@@ -0,0 +1,36 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/RoanBrand/SpectroDashboard/health"
+)
+
+var healthFunc func() *health.Report
+
+// SetupHealth serves the health of each component on /healthz, and readiness on /readyz.
+// Both respond with 503 Service Unavailable when unhealthy or not ready.
+func SetupHealth(healthGetter func() *health.Report) {
+	http.HandleFunc("/healthz", healthEndpoint)
+	http.HandleFunc("/readyz", healthEndpoint)
+	healthFunc = healthGetter
+}
+
+func healthEndpoint(w http.ResponseWriter, r *http.Request) {
+	report := healthFunc()
+
+	status := http.StatusOK
+	if r.URL.Path == "/readyz" {
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+	} else if report.Status == health.StatusDown {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/log"
+)
+
+var heatsFunc func(furnace, heat string, from, to time.Time) (interface{}, error)
+
+// SetupHeats serves the samples of furnaces grouped into heats on /heats.
+func SetupHeats(heatsGetter func(string, string, time.Time, time.Time) (interface{}, error)) {
+	http.HandleFunc("/heats", heatsEndpoint)
+	heatsFunc = heatsGetter
+}
+
+// e.g. /heats?f=F1&from=2023-05-01&to=2023-05-02&heat=1234. All are optional.
+func heatsEndpoint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := ParseTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from time: "+q.Get("from"), http.StatusBadRequest)
+		return
+	}
+	to, err := ParseTime(q.Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to time: "+q.Get("to"), http.StatusBadRequest)
+		return
+	}
+
+	res, err := heatsFunc(q.Get("f"), q.Get("heat"), from, to)
+	if err != nil {
+		errMsg := "Error querying heats: " + err.Error()
+		log.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -5,25 +5,35 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var server http.Server
 
-var resultsFunc func() ([]byte, error)
-var furnaceResultFunc func(furnaces []string, tSamplesOnly bool) (interface{}, error)
+var resultsFunc func(sampleType string, withSources bool) ([]byte, error)
+var furnaceResultFunc func(furnaces []string, sampleType string) (interface{}, error)
+var resultFunc func(spectro int, id string) (*sample.Record, error)
 
 func SetupServer(
 	staticFilesPath string,
-	resultsGetter func() ([]byte, error),
-	furnaceResultGetter func([]string, bool) (interface{}, error),
+	resultsGetter func(string, bool) ([]byte, error),
+	furnaceResultGetter func([]string, string) (interface{}, error),
+	resultGetter func(int, string) (*sample.Record, error),
 ) {
 	http.Handle("/", http.FileServer(http.Dir(staticFilesPath)))
 	http.HandleFunc("/results", resultEndpoint)
+	http.HandleFunc("/results/stream", resultStream)
+	http.HandleFunc("/results/latest", latestEvent)
+	http.HandleFunc("/result", singleResult)
 	http.HandleFunc("/lastfurnaceresults", lastFurnaceResult)
+	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/gettime", func(w http.ResponseWriter, r *http.Request) {
 		sysTime := struct {
 			T time.Time `json:"t"`
@@ -37,12 +47,14 @@ func SetupServer(
 
 	resultsFunc = resultsGetter
 	furnaceResultFunc = furnaceResultGetter
+	resultFunc = resultGetter
 }
 
 func StartServer(port string) error {
 	log.Println("Starting SpectroDashboard service")
 	//return http.ListenAndServe(":"+port, nil)
 	server.Addr = ":" + port
+	server.RegisterOnShutdown(stream.close)
 	err := server.ListenAndServe()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
@@ -57,8 +69,16 @@ func StopServer() error {
 	return server.Shutdown(ctx)
 }
 
+// latest samples, optionally of a sample type, e.g. /results?type=bath.
+// With sources=true, an object of the samples and the status of each source, e.g. {"samples": [...], "sources": [...]}.
 func resultEndpoint(w http.ResponseWriter, r *http.Request) {
-	resp, err := resultsFunc()
+	sampleType, err := sampleTypeParam(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := resultsFunc(sampleType, r.URL.Query().Get("sources") == "true")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -69,13 +89,30 @@ func resultEndpoint(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
+// ID of the last new sample event on /results/stream, e.g. {"last_event_id":1683000000000}.
+// Other services probe it to find new samples without reading them.
+func latestEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&latestEventResponse{LastEventID: stream.last()})
+}
+
+type latestEventResponse struct {
+	LastEventID uint64 `json:"last_event_id"`
+}
+
 func lastFurnaceResult(w http.ResponseWriter, r *http.Request) {
 	if furnaceResultFunc == nil {
 		return
 	}
 
 	q := r.URL.Query()
-	results, err := furnaceResultFunc(q["f"], q["t"] != nil && q["t"][0] == "true")
+	sampleType, err := sampleTypeParam(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := furnaceResultFunc(q["f"], sampleType)
 	if err != nil {
 		errMsg := "Error querying results: " + err.Error()
 		log.Println(errMsg)
@@ -91,32 +128,104 @@ func lastFurnaceResult(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sampleTypeParam returns the sample type results are filtered by, e.g. ?type=tap, or "" for all.
+// ?t=true of older clients is tap samples.
+func sampleTypeParam(q url.Values) (string, error) {
+	sampleType := q.Get("type")
+	if sampleType == "" {
+		if q.Get("t") == "true" {
+			return sample.TypeTap, nil
+		}
+		return "", nil
+	}
+
+	sampleType = strings.ToLower(sampleType)
+	if !sample.ValidType(sampleType) {
+		return "", errors.New("unknown sample type: " + q.Get("type"))
+	}
+	return sampleType, nil
+}
+
+// single sample by spectro and source ID, e.g. /result?s=2&id=1234
+func singleResult(w http.ResponseWriter, r *http.Request) {
+	if resultFunc == nil {
+		return
+	}
+
+	q := r.URL.Query()
+	spectro, err := strconv.Atoi(q.Get("s"))
+	if err != nil {
+		http.Error(w, "invalid spectro number: "+q.Get("s"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := resultFunc(spectro, q.Get("id"))
+	if err != nil {
+		if errors.Is(err, sample.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		errMsg := "Error querying result: " + err.Error()
+		log.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // for tv
 func GetRemoteResults(remoteAddress string) (*http.Response, error) {
+	return http.Get(remoteResultsURL(remoteAddress))
+}
+
+func GetRemoteLatestFurnacesResults(remoteAddress string, furnaces []string) (*http.Response, error) {
+	return http.Get(remoteLatestFurnacesResultsURL(remoteAddress, furnaces, ""))
+}
+
+func GetRemoteResult(remoteAddress string, spectro int, id string) (*http.Response, error) {
+	return http.Get(remoteResultURL(remoteAddress, spectro, id))
+}
+
+func remoteURL(remoteAddress, path string) string {
 	if !strings.HasPrefix(remoteAddress, "http://") {
 		remoteAddress = "http://" + remoteAddress
 	}
-	if !strings.HasSuffix(remoteAddress, "/results") {
-		remoteAddress = remoteAddress + "/results"
+	if !strings.HasSuffix(remoteAddress, path) {
+		remoteAddress = remoteAddress + path
 	}
-	return http.Get(remoteAddress)
+	return remoteAddress
 }
 
-func GetRemoteLatestFurnacesResults(remoteAddress string, furnaces []string) (*http.Response, error) {
-	if !strings.HasPrefix(remoteAddress, "http://") {
-		remoteAddress = "http://" + remoteAddress
+func remoteResultsURL(remoteAddress string) string {
+	return remoteURL(remoteAddress, "/results")
+}
+
+func remoteLatestEventURL(remoteAddress string) string {
+	return remoteURL(remoteAddress, "/results/latest")
+}
+
+func remoteLatestFurnacesResultsURL(remoteAddress string, furnaces []string, sampleType string) string {
+	q := url.Values{"f": furnaces}
+	if sampleType != "" {
+		q.Set("type", sampleType)
 	}
-	if !strings.HasSuffix(remoteAddress, "/lastfurnaceresults") {
-		remoteAddress = remoteAddress + "/lastfurnaceresults"
+	if sampleType == sample.TypeTap {
+		q.Set("t", "true") // for older services
 	}
-	for i, furnace := range furnaces {
-		if i == 0 {
-			remoteAddress += "?f="
-		} else {
-			remoteAddress += "&f="
-		}
-		remoteAddress += furnace
+	remoteAddress = remoteURL(remoteAddress, "/lastfurnaceresults")
+	if len(q) > 0 {
+		remoteAddress += "?" + q.Encode()
 	}
+	return remoteAddress
+}
 
-	return http.Get(remoteAddress)
+func remoteResultURL(remoteAddress string, spectro int, id string) string {
+	return remoteURL(remoteAddress, "/result") + "?s=" + strconv.Itoa(spectro) + "&id=" + url.QueryEscape(id)
 }
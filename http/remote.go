@@ -0,0 +1,312 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/metrics"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/samplename"
+)
+
+// Failed calls to remote machines are retried, while within their timeout.
+const remoteAttempts = 3
+
+var remoteRetryDelay = time.Millisecond * 250
+
+// RemoteSource reads sample results of a spectro from another SpectroDashboard service.
+// Samples of other spectros the service mixes in are ignored, as they are read from their own sources.
+type RemoteSource struct {
+	address string
+	spectro int
+	timeout time.Duration // of each call, including retries
+	names   *samplename.Parser
+	client  *http.Client
+	breaker breaker
+}
+
+// NewRemoteSource parses sample names with names, if not nil, instead of using the fields the service reports.
+func NewRemoteSource(address string, spectro int, timeout time.Duration, names *samplename.Parser) *RemoteSource {
+	s := &RemoteSource{address: address, spectro: spectro, timeout: timeout, names: names, client: &http.Client{}}
+	s.breaker.onChange = func(open bool) {
+		if open {
+			metrics.RemoteCircuitOpen.WithLabelValues(metrics.Spectro(spectro)).Set(1)
+		} else {
+			metrics.RemoteCircuitOpen.WithLabelValues(metrics.Spectro(spectro)).Set(0)
+		}
+	}
+	return s
+}
+
+// get calls the remote machine, retrying connection failures and server errors until the timeout.
+// Calls are not made while the machine cools down after failing repeatedly.
+func (s *RemoteSource) get(url string) (*http.Response, error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	resp, err := s.getWithRetry(ctx, url)
+	s.breaker.done(err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (s *RemoteSource) getWithRetry(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := s.client.Do(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("remote %s responded with %s", s.address, resp.Status)
+		}
+
+		if attempt == remoteAttempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(remoteRetryDelay * time.Duration(attempt)):
+		}
+	}
+}
+
+// cancelOnClose cancels the context of a request when its response is read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func (s *RemoteSource) Spectro() int {
+	return s.spectro
+}
+
+func (s *RemoteSource) LatestResults(numResults int) ([]*sample.Record, error) {
+	resp, err := s.get(remoteResultsURL(s.address))
+	if err != nil {
+		return nil, err
+	}
+
+	recs, err := s.decodeRecords(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recs) > numResults {
+		recs = recs[:numResults]
+	}
+	return recs, nil
+}
+
+// LastFurnaceResults of a sample type are those the service finds by its own sample type rules,
+// that are also of the type by the rules here.
+func (s *RemoteSource) LastFurnaceResults(furnaces []string, sampleType string) ([]*sample.Record, error) {
+	resp, err := s.get(remoteLatestFurnacesResultsURL(s.address, furnaces, sampleType))
+	if err != nil {
+		return nil, err
+	}
+
+	recs, err := s.decodeRecords(resp)
+	if err != nil || sampleType == "" {
+		return recs, err
+	}
+
+	ofType := recs[:0]
+	for _, r := range recs {
+		if r.Type == sampleType {
+			ofType = append(ofType, r)
+		}
+	}
+	return ofType, nil
+}
+
+func (s *RemoteSource) ResultByID(id string) (*sample.Record, error) {
+	resp, err := s.get(remoteResultURL(s.address, s.spectro, id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, sample.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote %s responded with %s", s.address, resp.Status)
+	}
+
+	var rr remoteRecord
+	if err = json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return nil, err
+	}
+
+	r := rr.record(s.spectro)
+	if r.Spectro != s.spectro {
+		return nil, sample.ErrNotFound
+	}
+	s.names.Parse(r)
+	return r, nil
+}
+
+// LatestChange returns the ID of the last new sample event of the remote service, to find new samples
+// without reading them. Older services that do not report it return sample.ErrNotFound.
+func (s *RemoteSource) LatestChange() (string, error) {
+	resp, err := s.get(remoteLatestEventURL(s.address))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", sample.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("remote %s responded with %s", s.address, resp.Status)
+	}
+
+	var le latestEventResponse
+	if err = json.NewDecoder(resp.Body).Decode(&le); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(le.LastEventID, 10), nil
+}
+
+// Check that the remote service is reachable. It is called even while cooling down.
+func (s *RemoteSource) Check() error {
+	c := http.Client{Timeout: s.timeout}
+	resp, err := c.Get(remoteURL(s.address, "/gettime"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote %s responded with %s", s.address, resp.Status)
+	}
+	return nil
+}
+
+func (s *RemoteSource) decodeRecords(resp *http.Response) ([]*sample.Record, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote %s responded with %s", s.address, resp.Status)
+	}
+
+	// current services respond to /results with the samples and status of their sources
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '{' {
+		var results struct {
+			Samples json.RawMessage `json:"samples"`
+		}
+		if err := json.Unmarshal(body, &results); err != nil {
+			return nil, err
+		}
+		body = results.Samples
+	}
+
+	var remoteRecs []remoteRecord
+	if err := json.Unmarshal(body, &remoteRecs); err != nil {
+		return nil, err
+	}
+
+	recs := make([]*sample.Record, 0, len(remoteRecs))
+	for i := range remoteRecs {
+		if r := remoteRecs[i].record(s.spectro); r.Spectro == s.spectro {
+			s.names.Parse(r)
+			recs = append(recs, r)
+		}
+	}
+
+	return recs, nil
+}
+
+// remoteRecord decodes samples from current services, as well as from older XML services
+// that reported the sample name as "id" and the results as a map of element to value.
+type remoteRecord struct {
+	ID         string          `json:"id"`
+	SampleName string          `json:"sample_name"`
+	Furnace    string          `json:"furnace"`
+	Type       string          `json:"type"`
+	Heat       string          `json:"heat"`
+	Sequence   int             `json:"sequence"`
+	Shift      string          `json:"shift"`
+	TimeStamp  time.Time       `json:"time_stamp"`
+	Method     string          `json:"method"`
+	Operator   string          `json:"operator"`
+	Results    json.RawMessage `json:"results"`
+	Spectro    int
+}
+
+func (rr *remoteRecord) record(spectro int) *sample.Record {
+	r := &sample.Record{
+		ID:         rr.ID,
+		SampleName: rr.SampleName,
+		Furnace:    rr.Furnace,
+		Type:       rr.Type,
+		Heat:       rr.Heat,
+		Sequence:   rr.Sequence,
+		Shift:      rr.Shift,
+		TimeStamp:  rr.TimeStamp,
+		Method:     rr.Method,
+		Operator:   rr.Operator,
+		Spectro:    rr.Spectro,
+	}
+
+	if r.SampleName == "" {
+		r.SampleName = rr.ID
+	}
+	if r.Spectro == 0 {
+		r.Spectro = spectro
+	}
+
+	res := bytes.TrimSpace(rr.Results)
+	if len(res) == 0 {
+		return r
+	}
+
+	if res[0] == '{' {
+		json.Unmarshal(res, &r.ResultsMap)
+		return r
+	}
+
+	var elResults []sample.ElementResult
+	if err := json.Unmarshal(res, &elResults); err != nil {
+		return r
+	}
+
+	r.ResultsMap = make(map[string]float64, len(elResults))
+	for _, er := range elResults {
+		if er.Element != "" {
+			r.ResultsMap[er.Element] = er.Value
+		}
+	}
+
+	return r
+}
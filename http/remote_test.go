@@ -0,0 +1,112 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestRemoteSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/results":
+			// current service mixing in another spectro, and an older service without spectro
+			w.Write([]byte(`[
+				{"sample_name": "A2", "furnace": "F1", "time_stamp": "2023-05-01T10:00:00Z", "results": [{"element": "C", "value": 3.4}], "Spectro": 4},
+				{"sample_name": "B1", "furnace": "F2", "time_stamp": "2023-05-01T09:00:00Z", "Spectro": 2},
+				{"id": "A1", "furnace": "F1", "time_stamp": "2023-05-01T08:00:00Z", "results": {"C": 3.3}}
+			]`))
+		case "/new/results":
+			w.Write([]byte(`{"samples": [{"sample_name": "A3", "time_stamp": "2023-05-01T11:00:00Z", "Spectro": 4}], "sources": []}`))
+		case "/slow/results":
+			time.Sleep(time.Millisecond * 200)
+			w.Write([]byte(`[]`))
+		case "/results/latest":
+			w.Write([]byte(`{"last_event_id": 42}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewRemoteSource(srv.URL, 4, time.Second, nil)
+	recs, err := s.LatestResults(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].SampleName != "A2" || recs[1].SampleName != "A1" {
+		t.Fatalf("unexpected samples: %+v", recs)
+	}
+	if recs[0].ResultsMap["C"] != 3.4 || recs[1].ResultsMap["C"] != 3.3 || recs[1].Spectro != 4 {
+		t.Fatalf("unexpected results: %+v %+v", recs[0], recs[1])
+	}
+
+	recs, err = NewRemoteSource(srv.URL+"/new", 4, time.Second, nil).LatestResults(10)
+	if err != nil || len(recs) != 1 || recs[0].SampleName != "A3" {
+		t.Fatalf("unexpected samples with source status: %+v %v", recs, err)
+	}
+
+	if v, err := s.LatestChange(); err != nil || v != "42" {
+		t.Fatalf("unexpected latest change: %q %v", v, err)
+	}
+	if _, err = NewRemoteSource(srv.URL+"/new", 4, time.Second, nil).LatestChange(); !errors.Is(err, sample.ErrNotFound) {
+		t.Fatalf("expected older service without latest change, got %v", err)
+	}
+
+	slow := NewRemoteSource(srv.URL+"/slow", 4, time.Millisecond*50, nil)
+	if _, err = slow.LatestResults(10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestRemoteRetryAndBreaker(t *testing.T) {
+	defer func(d time.Duration) { remoteRetryDelay = d }(remoteRetryDelay)
+	remoteRetryDelay = time.Millisecond
+
+	calls, failFirst := 0, 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= failFirst {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	s := NewRemoteSource(srv.URL, 4, time.Second, nil)
+	if _, err := s.LatestResults(10); err != nil || calls != 3 {
+		t.Fatalf("expected success after retries, got %v after %d calls", err, calls)
+	}
+
+	// every attempt fails
+	calls, failFirst = 0, 1000
+	for i := 0; i < breakerThreshold; i++ {
+		if _, err := s.LatestResults(10); err == nil {
+			t.Fatal("expected failure")
+		}
+	}
+	if calls != breakerThreshold*remoteAttempts {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+
+	var open *ErrCircuitOpen
+	if _, err := s.LatestResults(10); !errors.As(err, &open) || calls != breakerThreshold*remoteAttempts {
+		t.Fatalf("expected open circuit without calls, got %v after %d calls", err, calls)
+	}
+
+	// after cool-down, a trial call closes the circuit again
+	s.breaker.openUntil = time.Now()
+	failFirst = 0
+	if _, err := s.LatestResults(10); err != nil {
+		t.Fatalf("expected trial call to succeed, got %v", err)
+	}
+	if err := s.breaker.allow(); err != nil {
+		t.Fatalf("expected closed circuit, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/history"
+	"github.com/RoanBrand/SpectroDashboard/log"
+)
+
+var sampleQueryFunc func(q history.Query) (*history.Page, error)
+
+// SetupSampleQuery serves the sample history on /samples.
+func SetupSampleQuery(queryFunc func(history.Query) (*history.Page, error)) {
+	http.HandleFunc("/samples", samplesEndpoint)
+	sampleQueryFunc = queryFunc
+}
+
+// e.g. /samples?from=2023-05-01&to=2023-05-02&f=F1&s=2&name=A1&method=Fe-10&type=tap&heat=1234&shift=B&limit=50&cursor=...
+func samplesEndpoint(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSampleQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := sampleQueryFunc(q)
+	if err != nil {
+		if errors.Is(err, history.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		errMsg := "Error querying samples: " + err.Error()
+		log.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseSampleQuery(v url.Values) (history.Query, error) {
+	q := history.Query{
+		Furnace:    v.Get("f"),
+		NamePrefix: v.Get("name"),
+		Method:     v.Get("method"),
+		Heat:       v.Get("heat"),
+		Shift:      v.Get("shift"),
+		Cursor:     v.Get("cursor"),
+	}
+
+	var err error
+	if q.Type, err = sampleTypeParam(v); err != nil {
+		return q, err
+	}
+	if q.From, err = ParseTime(v.Get("from")); err != nil {
+		return q, errors.New("invalid from time: " + v.Get("from"))
+	}
+	if q.To, err = ParseTime(v.Get("to")); err != nil {
+		return q, errors.New("invalid to time: " + v.Get("to"))
+	}
+
+	if s := v.Get("s"); s != "" {
+		if q.Spectro, err = strconv.Atoi(s); err != nil {
+			return q, errors.New("invalid spectro number: " + s)
+		}
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil {
+			return q, errors.New("invalid limit: " + l)
+		}
+	}
+
+	return q, nil
+}
+
+// ParseTime accepts RFC 3339 times, or local date and time without time zone. Empty is zero time.
+func ParseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New("invalid time: " + s)
+}
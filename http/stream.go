@@ -0,0 +1,206 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+const (
+	streamBacklog   = 200 // number of past events kept for clients that reconnect
+	streamHeartbeat = time.Second * 30
+	streamRetryMs   = 3000 // client reconnect delay
+)
+
+var stream = newBroker()
+
+// broker fans out new sample events to all connected stream clients.
+type broker struct {
+	mu      sync.Mutex
+	lastID  uint64
+	backlog []streamEvent // oldest first
+	clients map[chan streamEvent]struct{}
+	closed  bool
+}
+
+type streamEvent struct {
+	id         uint64
+	data       []byte
+	sampleType string
+}
+
+func newBroker() *broker {
+	return &broker{
+		// start from current time, so that event IDs keep increasing over service restarts.
+		lastID:  uint64(time.Now().UnixMilli()),
+		clients: make(map[chan streamEvent]struct{}),
+	}
+}
+
+// PublishSamples sends new samples, oldest first, to all clients connected to /results/stream.
+func PublishSamples(recs []*sample.Record) {
+	for _, r := range recs {
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Println("failed to encode sample for stream:", err)
+			continue
+		}
+		stream.publish(data, r.Type)
+	}
+}
+
+// last returns the ID of the last event published.
+func (b *broker) last() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastID
+}
+
+func (b *broker) publish(data []byte, sampleType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	ev := streamEvent{id: b.lastID, data: data, sampleType: sampleType}
+
+	if len(b.backlog) == streamBacklog {
+		copy(b.backlog, b.backlog[1:])
+		b.backlog = b.backlog[:streamBacklog-1]
+	}
+	b.backlog = append(b.backlog, ev)
+
+	for c := range b.clients {
+		select {
+		case c <- ev:
+		default:
+			// client too slow, it will catch up from backlog when it reconnects.
+			delete(b.clients, c)
+			close(c)
+		}
+	}
+}
+
+// subscribe returns a channel of new events, along with the events after lastID still in the backlog.
+func (b *broker) subscribe(lastID uint64) (chan streamEvent, []streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan streamEvent, 16)
+	if b.closed {
+		close(c)
+		return c, nil
+	}
+	b.clients[c] = struct{}{}
+
+	if lastID >= b.lastID {
+		return c, nil
+	}
+
+	var missed []streamEvent
+	for i, ev := range b.backlog {
+		if ev.id > lastID {
+			missed = append(missed, b.backlog[i:]...)
+			break
+		}
+	}
+	return c, missed
+}
+
+func (b *broker) unsubscribe(c chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+// close disconnects all clients, so that the server can shut down.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for c := range b.clients {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+// Server-Sent Events of new samples, optionally of a sample type, e.g. /results/stream?type=tap.
+// Clients that reconnect with Last-Event-ID receive the samples they missed.
+func resultStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sampleType, err := sampleTypeParam(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lastIDStr := r.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = r.URL.Query().Get("lastEventId")
+	}
+
+	var c chan streamEvent
+	var missed []streamEvent
+	if lastID, err := strconv.ParseUint(lastIDStr, 10, 64); err == nil {
+		c, missed = stream.subscribe(lastID)
+	} else {
+		c, _ = stream.subscribe(^uint64(0))
+	}
+	defer stream.unsubscribe(c)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMs)
+	for _, ev := range missed {
+		if sampleType == "" || ev.sampleType == sampleType {
+			writeEvent(w, ev)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev, ok := <-c:
+			if !ok {
+				return
+			}
+			if sampleType != "" && ev.sampleType != sampleType {
+				continue
+			}
+			writeEvent(w, ev)
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev streamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: sample\ndata: %s\n\n", ev.id, ev.data)
+}
@@ -3,11 +3,16 @@ package mdb_spectro
 import (
 	"database/sql"
 	"fmt"
+	"math"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/samplename"
 	_ "github.com/mattn/go-adodb"
 )
 
@@ -15,6 +20,9 @@ import (
 // DB is a file on disk anyway.
 var querySerializer sync.Mutex
 
+// Longest time between a sample being measured and stored.
+const storeDelay = time.Hour
+
 /*type record struct {
 	SampleId   int64
 	SampleName string
@@ -53,91 +61,290 @@ var elementMap = map[string]string{
 	"0x00000033-Fe": "Fe",
 }
 
-func GetLastFurnaceResults(dsn string, furnaces []string, tSamplesOnly bool) ([]sample.Record, error) {
+// Source reads sample results from a spectro machine's Access database file.
+type Source struct {
+	dsn     string
+	spectro int
+	names   *samplename.Parser
+
+	mu      sync.Mutex
+	readErr error // of the last read of the latest samples or change, reported by Check
+}
+
+// NewSource parses sample names with names, if not nil.
+func NewSource(dsn string, spectro int, names *samplename.Parser) *Source {
+	return &Source{dsn: dsn, spectro: spectro, names: names}
+}
+
+func (s *Source) Spectro() int {
+	return s.spectro
+}
+
+// Samples of a furnace are searched for one of a sample type in pages of furnaceTypeSearch, latest first,
+// up to furnaceTypeSearchMax samples.
+const (
+	furnaceTypeSearch    = 100
+	furnaceTypeSearchMax = 2000
+)
+
+func (s *Source) LastFurnaceResults(furnaces []string, sampleType string) ([]*sample.Record, error) {
+	if len(furnaces) == 0 {
+		return nil, nil
+	}
+
 	querySerializer.Lock()
 	defer querySerializer.Unlock()
 
-	db, err := sql.Open("adodb", dsn)
+	db, err := sql.Open("adodb", s.dsn)
 	if err != nil {
 		return nil, fmt.Errorf("error opening db: %v", err)
 	}
 	defer db.Close()
 
+	var recs []*sample.Record
+	if sampleType == "" {
+		recs, err = s.lastOfFurnaces(db, furnaces)
+	} else {
+		recs, err = s.lastOfFurnacesOfType(db, furnaces, sampleType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = queryMeasurements(db, recs); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+func (s *Source) lastOfFurnaces(db *sql.DB, furnaces []string) ([]*sample.Record, error) {
 	qry := strings.Builder{}
-	for _, f := range furnaces {
+	args := make([]interface{}, len(furnaces))
+	for i, f := range furnaces {
 		qry.WriteString(`
-			(SELECT TOP 1 SampleName, Quality, StoreDateTime
-			FROM KSampleResultTbl WHERE UCASE(Quality) = '` + strings.ToUpper(f) + `'`)
-		if tSamplesOnly {
-			qry.WriteString(` AND UCASE(Right(SampleName,1)) = 'T' `)
-		}
-		qry.WriteString(` ORDER BY SampleResultID DESC) UNION `)
+			(SELECT TOP 1 SampleResultID, SampleName, Quality, StoreDateTime
+			FROM KSampleResultTbl WHERE UCASE(Quality) = ?
+			ORDER BY SampleResultID DESC) UNION `)
+		args[i] = strings.ToUpper(f)
 	}
 
 	qryStr := qry.String()[:qry.Len()-7]
-	sampleRows, err := db.Query(qryStr)
+	sampleRows, err := db.Query(qryStr, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying 'KSampleResultTbl': %v", err)
 	}
 	defer sampleRows.Close()
 
-	recs := make([]sample.Record, len(furnaces))
+	recs := make([]*sample.Record, 0, len(furnaces))
 
-	i := 0
 	for sampleRows.Next() {
-		err := sampleRows.Scan(&recs[i].SampleName, &recs[i].Furnace, &recs[i].TimeStamp)
+		r := &sample.Record{Spectro: s.spectro}
+		err := sampleRows.Scan(&r.SampleId, &r.SampleName, &r.Furnace, &r.TimeStamp)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning row from 'KSampleResultTbl': %v", err)
 		}
 
-		i++
+		r.ID = strconv.FormatInt(r.SampleId, 10)
+		s.names.Parse(r)
+		recs = append(recs, r)
 	}
 
-	return recs[:i], nil
+	return recs, nil
 }
 
-func GetResults(dsn string, numResults int) ([]*sample.Record, error) {
+// lastOfFurnacesOfType searches the latest samples of each furnace for one of the sample type,
+// as types are parsed from sample names by rules that can't be expressed in a query.
+// Furnaces without one in their last furnaceTypeSearchMax samples are left out.
+func (s *Source) lastOfFurnacesOfType(db *sql.DB, furnaces []string, sampleType string) ([]*sample.Record, error) {
+	recs := make([]*sample.Record, 0, len(furnaces))
+
+	for _, f := range furnaces {
+		r, err := s.lastOfFurnaceOfType(db, f, sampleType)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			recs = append(recs, r)
+		}
+	}
+
+	return recs, nil
+}
+
+func (s *Source) lastOfFurnaceOfType(db *sql.DB, furnace, sampleType string) (*sample.Record, error) {
+	before := int32(math.MaxInt32) // SampleResultID is an Access long integer
+	for searched := 0; searched < furnaceTypeSearchMax; searched += furnaceTypeSearch {
+		latest, err := s.querySamples(db, `
+			SELECT TOP `+strconv.Itoa(furnaceTypeSearch)+`
+			SampleResultID, SampleName, Quality
+			FROM KSampleResultTbl
+			WHERE UCASE(Quality) = ? AND SampleResultID < ?
+			ORDER BY SampleResultID DESC;`, furnaceTypeSearch, strings.ToUpper(furnace), before)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range latest {
+			if r.Type == sampleType {
+				return r, nil
+			}
+		}
+		if len(latest) < furnaceTypeSearch {
+			return nil, nil
+		}
+		before = int32(latest[len(latest)-1].SampleId)
+	}
+
+	return nil, nil
+}
+
+func (s *Source) LatestResults(numResults int) ([]*sample.Record, error) {
+	recs, err := s.latestResults(numResults)
+	s.readDone(err)
+	return recs, err
+}
+
+func (s *Source) latestResults(numResults int) ([]*sample.Record, error) {
 	querySerializer.Lock()
 	defer querySerializer.Unlock()
 
-	db, err := sql.Open("adodb", dsn)
+	db, err := sql.Open("adodb", s.dsn)
 	if err != nil {
 		return nil, fmt.Errorf("error opening db: %v", err)
 	}
 	defer db.Close()
 
-	sampleRows, err := db.Query(`
-		SELECT TOP ` + strconv.Itoa(numResults) + ` 
+	recs, err := s.querySamples(db, `
+		SELECT TOP `+strconv.Itoa(numResults)+` 
 		SampleResultID, SampleName, Quality
 		FROM KSampleResultTbl
-		ORDER BY SampleResultID DESC;`)
+		ORDER BY SampleResultID DESC;`, numResults)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = queryMeasurements(db, recs); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// ResultsBetween returns samples measured from, up to but excluding, to, in time order. Zero to is unbounded.
+func (s *Source) ResultsBetween(from, to time.Time) ([]*sample.Record, error) {
+	querySerializer.Lock()
+	defer querySerializer.Unlock()
+
+	db, err := sql.Open("adodb", s.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening db: %v", err)
+	}
+	defer db.Close()
+
+	// samples are stored shortly after they are measured
+	qry := strings.Builder{}
+	qry.WriteString(`
+		SELECT SampleResultID, SampleName, Quality
+		FROM KSampleResultTbl
+		WHERE StoreDateTime >= #` + from.Format("2006-01-02 15:04:05") + `#`)
+	if !to.IsZero() {
+		qry.WriteString(` AND StoreDateTime < #` + to.Add(storeDelay).Format("2006-01-02 15:04:05") + `#`)
+	}
+	qry.WriteString(` ORDER BY SampleResultID;`)
+
+	recs, err := s.querySamples(db, qry.String(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = queryMeasurements(db, recs); err != nil {
+		return nil, err
+	}
+
+	inRange := recs[:0]
+	for _, r := range recs {
+		if !r.TimeStamp.Before(from) && (to.IsZero() || r.TimeStamp.Before(to)) {
+			inRange = append(inRange, r)
+		}
+	}
+	sort.SliceStable(inRange, func(i, j int) bool {
+		return inRange[i].TimeStamp.Before(inRange[j].TimeStamp)
+	})
+
+	return inRange, nil
+}
+
+func (s *Source) ResultByID(id string) (*sample.Record, error) {
+	sampleId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, sample.ErrNotFound
+	}
+
+	querySerializer.Lock()
+	defer querySerializer.Unlock()
+
+	db, err := sql.Open("adodb", s.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening db: %v", err)
+	}
+	defer db.Close()
+
+	recs, err := s.querySamples(db, `
+		SELECT SampleResultID, SampleName, Quality
+		FROM KSampleResultTbl
+		WHERE SampleResultID = `+strconv.FormatInt(sampleId, 10)+`;`, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, sample.ErrNotFound
+	}
+
+	if err = queryMeasurements(db, recs); err != nil {
+		return nil, err
+	}
+
+	return recs[0], nil
+}
+
+// querySamples runs a query with args that selects SampleResultID, SampleName and Quality from 'KSampleResultTbl'.
+func (s *Source) querySamples(db *sql.DB, qry string, sizeHint int, args ...interface{}) ([]*sample.Record, error) {
+	sampleRows, err := db.Query(qry, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying 'KSampleResultTbl': %v", err)
 	}
 	defer sampleRows.Close()
 
-	recs := make([]*sample.Record, 0, numResults)
+	recs := make([]*sample.Record, 0, sizeHint)
 
 	for sampleRows.Next() {
 		var sampleName sql.NullString
 		var furnace sql.NullString
-		r := new(sample.Record)
+		r := &sample.Record{Spectro: s.spectro}
 
 		err := sampleRows.Scan(&r.SampleId, &sampleName, &furnace)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning row from 'KSampleResultTbl': %v", err)
 		}
 
+		r.ID = strconv.FormatInt(r.SampleId, 10)
 		if sampleName.Valid {
 			r.SampleName = sampleName.String
 		}
 		if furnace.Valid {
 			r.Furnace = furnace.String
 		}
+		s.names.Parse(r)
 
 		recs = append(recs, r)
 	}
 
+	return recs, nil
+}
+
+// queryMeasurements fills in the timestamp and element results of each sample.
+func queryMeasurements(db *sql.DB, recs []*sample.Record) error {
 	for _, r := range recs {
 		measureResultRows, err := db.Query(`
 			SELECT m.Timestamp, r.ResultKey, r.Value
@@ -145,7 +352,7 @@ func GetResults(dsn string, numResults int) ([]*sample.Record, error) {
 			LEFT JOIN KResultValueTbl r ON ((r.MeasureResultID = m.MeasureResultID) AND (r.ResultType = 2) AND (r.Value > 0.0))
 			WHERE m.SampleResultID = ` + strconv.FormatInt(r.SampleId, 10) + ` AND m.ResultType = 1;`)
 		if err != nil {
-			return nil, fmt.Errorf("error querying 'KMeasureResultTbl': %v", err)
+			return fmt.Errorf("error querying 'KMeasureResultTbl': %v", err)
 		}
 
 		r.ResultsMap = make(map[string]float64, len(elementMap))
@@ -157,7 +364,7 @@ func GetResults(dsn string, numResults int) ([]*sample.Record, error) {
 			err := measureResultRows.Scan(&r.TimeStamp, &elCode, &elValue)
 			if err != nil {
 				measureResultRows.Close()
-				return nil, fmt.Errorf("error scanning row from 'KMeasureResultTbl': %v", err)
+				return fmt.Errorf("error scanning row from 'KMeasureResultTbl': %v", err)
 			}
 
 			if !elCode.Valid || !elValue.Valid {
@@ -173,5 +380,63 @@ func GetResults(dsn string, numResults int) ([]*sample.Record, error) {
 		measureResultRows.Close()
 	}
 
-	return recs, nil
+	return nil
+}
+
+// LatestChange returns the ID of the latest sample stored, to find new samples without reading them.
+func (s *Source) LatestChange() (string, error) {
+	id, err := s.latestChange()
+	s.readDone(err)
+	return id, err
+}
+
+func (s *Source) latestChange() (string, error) {
+	querySerializer.Lock()
+	defer querySerializer.Unlock()
+
+	db, err := sql.Open("adodb", s.dsn)
+	if err != nil {
+		return "", fmt.Errorf("error opening db: %v", err)
+	}
+	defer db.Close()
+
+	var id sql.NullInt64
+	if err = db.QueryRow(`SELECT MAX(SampleResultID) FROM KSampleResultTbl;`).Scan(&id); err != nil {
+		return "", fmt.Errorf("error querying 'KSampleResultTbl': %v", err)
+	}
+	if !id.Valid {
+		return "", nil
+	}
+	return strconv.FormatInt(id.Int64, 10), nil
+}
+
+func (s *Source) readDone(err error) {
+	s.mu.Lock()
+	s.readErr = err
+	s.mu.Unlock()
+}
+
+// Check that the database file exists, and that the last read of it succeeded.
+// It does not read the database, so it does not hold up reads.
+func (s *Source) Check() error {
+	if path := dataSourcePath(s.dsn); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readErr
+}
+
+// dataSourcePath returns the file path in a connection string, e.g. "Provider=...;Data Source=C:/db.mdb;"
+func dataSourcePath(dsn string) string {
+	for _, part := range strings.Split(dsn, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), "Data Source") {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
 }
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "spectrodashboard"
+
+var (
+	SourceReadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "source_read_duration_seconds",
+		Help:      "Duration of reads from result sources.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"spectro", "type", "operation"})
+
+	SourceReadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_read_errors_total",
+		Help:      "Failed reads from result sources. Type remote are failed fetches from remote machines.",
+	}, []string{"spectro", "type", "operation"})
+
+	RemoteCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_circuit_open",
+		Help:      "1 while a failing remote machine is not called, for a cool-down period.",
+	}, []string{"spectro"})
+
+	ResultsCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "results_cache_requests_total",
+		Help:      "Requests for latest results, by whether they were served from cache.",
+	}, []string{"result"}) // hit or miss
+
+	FurnaceCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "furnace_cache_requests_total",
+		Help:      "Requests for last furnace results, by whether they were served from cache or a read in progress.",
+	}, []string{"result"}) // hit or miss
+
+	ShopwareInserts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shopware_inserts_total",
+		Help:      "Attempts to insert new samples into Shopware, by result.",
+	}, []string{"spectro", "result"}) // success or failure
+
+	ShopwareInsertedSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shopware_inserted_samples_total",
+		Help:      "Samples inserted into Shopware.",
+	}, []string{"spectro"})
+
+	ShopwareInsertLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "shopware_insert_lag_seconds",
+		Help:      "Time from sample measurement until it was inserted into Shopware.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 900, 3600, 4 * 3600, 24 * 3600},
+	}, []string{"spectro"})
+
+	ShopwareOutboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "shopware_outbox_pending",
+		Help:      "Samples waiting in the outbox to be inserted into Shopware.",
+	})
+
+	ShopwareOutboxDead = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "shopware_outbox_dead",
+		Help:      "Samples Shopware rejected too often, kept in the outbox without being retried.",
+	})
+
+	SamplesIngested = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "samples_ingested_total",
+		Help:      "New samples produced by sources.",
+	}, []string{"spectro"})
+)
+
+// Spectro label value.
+func Spectro(spectro int) string {
+	return strconv.Itoa(spectro)
+}
+
+// InstrumentSource records the duration and errors of reads from a result source.
+func InstrumentSource(s sample.ResultSource, sourceType string) sample.ResultSource {
+	return &instrumentedSource{ResultSource: s, spectro: Spectro(s.Spectro()), sourceType: sourceType}
+}
+
+type instrumentedSource struct {
+	sample.ResultSource
+	spectro    string
+	sourceType string
+}
+
+func (s *instrumentedSource) observe(operation string, start time.Time, err error) {
+	SourceReadDuration.WithLabelValues(s.spectro, s.sourceType, operation).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, sample.ErrNotFound) {
+		SourceReadErrors.WithLabelValues(s.spectro, s.sourceType, operation).Inc()
+	}
+}
+
+func (s *instrumentedSource) LatestResults(numResults int) ([]*sample.Record, error) {
+	start := time.Now()
+	recs, err := s.ResultSource.LatestResults(numResults)
+	s.observe("latest", start, err)
+	return recs, err
+}
+
+func (s *instrumentedSource) LastFurnaceResults(furnaces []string, sampleType string) ([]*sample.Record, error) {
+	start := time.Now()
+	recs, err := s.ResultSource.LastFurnaceResults(furnaces, sampleType)
+	s.observe("furnace", start, err)
+	return recs, err
+}
+
+func (s *instrumentedSource) ResultByID(id string) (*sample.Record, error) {
+	start := time.Now()
+	r, err := s.ResultSource.ResultByID(id)
+	s.observe("by_id", start, err)
+	return r, err
+}
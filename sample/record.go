@@ -3,10 +3,20 @@ package sample
 import "time"
 
 type Record struct {
+	ID         string          `json:"id,omitempty"` // identifies the sample within its source
 	SampleName string          `json:"sample_name"`
 	Furnace    string          `json:"furnace"`
+	Type       string          `json:"type,omitempty"`     // sample type, if classified by its name
+	Heat       string          `json:"heat,omitempty"`     // parsed from sample name
+	Sequence   int             `json:"sequence,omitempty"` // of the sample in its heat, parsed from sample name
+	Shift      string          `json:"shift,omitempty"`    // parsed from sample name
 	TimeStamp  time.Time       `json:"time_stamp"`
+	Method     string          `json:"method,omitempty"`   // analysis method, if known
+	Operator   string          `json:"operator,omitempty"` // if known
 	Results    []ElementResult `json:"results,omitempty"`
+	Grade      string          `json:"grade,omitempty"` // grade the sample is checked against
+
+	GradeMatches []GradeMatch `json:"grade_matches,omitempty"` // grades closest to the sample's chemistry, best first
 
 	Spectro int // spectro machine from which the sample was taken
 
@@ -17,4 +27,47 @@ type Record struct {
 type ElementResult struct {
 	Element string  `json:"element"`
 	Value   float64 `json:"value"`
+	Status  string  `json:"status,omitempty"` // against grade spec, if any
+}
+
+// GradeMatch is how closely a sample's chemistry matches a grade.
+type GradeMatch struct {
+	Grade      string   `json:"grade"`
+	Distance   float64  `json:"distance"`             // 0 if all elements are within the grade's limits
+	Deviations []string `json:"deviations,omitempty"` // elements outside of the grade's limits
+}
+
+// Element result statuses against a grade spec.
+const (
+	StatusOK        = "ok"
+	StatusWarning   = "warning"
+	StatusOutOfSpec = "out_of_spec"
+)
+
+// Sample types, assigned by configured rules on sample names.
+const (
+	TypeTap   = "tap"   // taken when the furnace is tapped, the final chemistry of a melt
+	TypeBath  = "bath"  // taken from the furnace bath while melting
+	TypeLadle = "ladle" // taken from the ladle
+	TypeCheck = "check" // control samples, e.g. of standards
+)
+
+// ValidType is true for the known sample types.
+func ValidType(t string) bool {
+	switch t {
+	case TypeTap, TypeBath, TypeLadle, TypeCheck:
+		return true
+	}
+	return false
+}
+
+// SetDisplayResults fills Results from ResultsMap in the display order of elements.
+func (r *Record) SetDisplayResults(elementOrder map[string]int) {
+	r.Results = make([]ElementResult, len(elementOrder))
+	for el, order := range elementOrder {
+		if elRes, ok := r.ResultsMap[el]; ok {
+			r.Results[order].Element = el
+			r.Results[order].Value = elRes
+		}
+	}
 }
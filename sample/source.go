@@ -0,0 +1,35 @@
+package sample
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a ResultSource when a requested sample does not exist.
+var ErrNotFound = errors.New("sample not found")
+
+// ResultSource is a spectro machine, or a remote service, from which sample results are read.
+type ResultSource interface {
+	// Spectro returns the number of the spectro machine the results are from.
+	Spectro() int
+
+	// LatestResults returns the latest numResults samples, latest first.
+	LatestResults(numResults int) ([]*Record, error)
+
+	// LastFurnaceResults returns the latest sample of each of the furnaces that has one.
+	// If sampleType is not empty, only samples of that type are considered.
+	LastFurnaceResults(furnaces []string, sampleType string) ([]*Record, error)
+
+	// ResultByID returns the sample with the given ID, or ErrNotFound.
+	ResultByID(id string) (*Record, error)
+}
+
+// RangeSource is a ResultSource that can read all samples measured in a time range,
+// for comparing with, or filling in, other databases.
+type RangeSource interface {
+	ResultSource
+
+	// ResultsBetween returns samples measured from, up to but excluding, to, in time order.
+	// Zero to is unbounded.
+	ResultsBetween(from, to time.Time) ([]*Record, error)
+}
@@ -0,0 +1,40 @@
+package samplename
+
+import (
+	"regexp"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+)
+
+// typeRule assigns a sample type to sample names it matches.
+type typeRule struct {
+	sampleName *regexp.Regexp // nil matches all
+	typ        string
+}
+
+func newTypeRules(rules []config.SampleTypeRule) ([]typeRule, error) {
+	tr := make([]typeRule, len(rules))
+
+	for i, r := range rules {
+		tr[i].typ = r.Type
+		if r.SampleName != "" {
+			re, err := regexp.Compile(r.SampleName)
+			if err != nil {
+				return nil, err
+			}
+			tr[i].sampleName = re
+		}
+	}
+
+	return tr, nil
+}
+
+// classify returns the type of the first rule matching a sample name, or "" if none.
+func classify(rules []typeRule, sampleName string) string {
+	for _, r := range rules {
+		if r.sampleName == nil || r.sampleName.MatchString(sampleName) {
+			return r.typ
+		}
+	}
+	return ""
+}
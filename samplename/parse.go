@@ -0,0 +1,130 @@
+package samplename
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Parser fills in the fields operators encode in sample names, by the sample name formats in config,
+// and the sample type, by the sample name format or else the sample type rules.
+// A nil Parser leaves samples as they are.
+type Parser struct {
+	formats []format
+	types   []typeRule
+}
+
+type format struct {
+	re    *regexp.Regexp
+	types map[string]string // upper case type group value -> sample type
+}
+
+// Fields encoded in a sample name.
+type Fields struct {
+	Furnace  string
+	Heat     string
+	Sequence int
+	Type     string
+	Shift    string
+}
+
+func NewParser(conf *config.Config) (*Parser, error) {
+	types, err := newTypeRules(conf.SampleTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{formats: make([]format, len(conf.SampleNameFormats)), types: types}
+	for i, nf := range conf.SampleNameFormats {
+		if p.formats[i].re, err = regexp.Compile(nf.Pattern); err != nil {
+			return nil, err
+		}
+
+		p.formats[i].types = make(map[string]string, len(nf.Types))
+		for code, t := range nf.Types {
+			p.formats[i].types[strings.ToUpper(code)] = t
+		}
+	}
+
+	return p, nil
+}
+
+// Fields returns the fields of a sample name. Those not in it are zero.
+func (p *Parser) Fields(sampleName string) Fields {
+	var f Fields
+	if p == nil {
+		return f
+	}
+
+	for _, nf := range p.formats {
+		m := nf.re.FindStringSubmatch(sampleName)
+		if m == nil {
+			continue
+		}
+
+		for i, group := range nf.re.SubexpNames() {
+			switch v := strings.TrimSpace(m[i]); group {
+			case "furnace":
+				f.Furnace = v
+			case "heat":
+				f.Heat = v
+			case "sequence":
+				f.Sequence, _ = strconv.Atoi(v)
+			case "type":
+				f.Type = nf.typeOf(v)
+			case "shift":
+				f.Shift = v
+			}
+		}
+		break
+	}
+
+	if f.Type == "" {
+		f.Type = classify(p.types, sampleName)
+	}
+	return f
+}
+
+// typeOf returns the sample type of a type group value, or "" if unknown.
+func (nf *format) typeOf(v string) string {
+	if t, ok := nf.types[strings.ToUpper(v)]; ok {
+		return t
+	}
+	if v = strings.ToLower(v); sample.ValidType(v) {
+		return v
+	}
+	return ""
+}
+
+// Parse sets the fields encoded in the names of samples. The furnace is only set if a sample has none.
+func (p *Parser) Parse(recs ...*sample.Record) {
+	if p == nil {
+		return
+	}
+
+	for _, r := range recs {
+		f := p.Fields(r.SampleName)
+		r.Heat, r.Sequence, r.Type, r.Shift = f.Heat, f.Sequence, f.Type, f.Shift
+		if r.Furnace == "" {
+			r.Furnace = f.Furnace
+		}
+	}
+}
+
+// TypeOf returns the type of a sample by its name, or "" if unknown.
+func (p *Parser) TypeOf(sampleName string) string {
+	return p.Fields(sampleName).Type
+}
+
+// Match returns a filter of samples of a type. Empty type matches all samples.
+func (p *Parser) Match(sampleType string) func(r *sample.Record) bool {
+	if sampleType == "" {
+		return nil
+	}
+	return func(r *sample.Record) bool {
+		return p.TypeOf(r.SampleName) == sampleType
+	}
+}
@@ -0,0 +1,58 @@
+package samplename
+
+import (
+	"testing"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestParser(t *testing.T) {
+	p, err := NewParser(&config.Config{
+		SampleTypes: []config.SampleTypeRule{
+			{Type: sample.TypeTap, SampleName: "(?i)T$"},
+			{Type: sample.TypeCheck, SampleName: "^CHK"},
+			{Type: sample.TypeBath},
+		},
+		SampleNameFormats: []config.SampleNameFormat{
+			{Pattern: `^(?P<furnace>F\d)-(?P<heat>\d+)-(?P<sequence>\d+)(?P<type>[BL])?(?P<shift>[A-C])?$`, Types: map[string]string{"b": "bath", "L": "ladle"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]Fields{
+		"F1-1234-2B":  {Furnace: "F1", Heat: "1234", Sequence: 2, Type: "bath"},
+		"F2-1234-3LA": {Furnace: "F2", Heat: "1234", Sequence: 3, Type: "ladle", Shift: "A"},
+		"F2-1234-4C":  {Furnace: "F2", Heat: "1234", Sequence: 4, Type: "bath", Shift: "C"}, // type by rules
+		"1234T":       {Type: "tap"},
+		"CHK-1":       {Type: "check"},
+	}
+	for name, want := range tests {
+		if got := p.Fields(name); got != want {
+			t.Errorf("fields of %q: expected %+v, got %+v", name, want, got)
+		}
+	}
+
+	recs := []*sample.Record{{SampleName: "F1-1234-2B", Furnace: "F3"}, {SampleName: "1234T"}}
+	p.Parse(recs...)
+	if r := recs[0]; r.Furnace != "F3" || r.Heat != "1234" || r.Sequence != 2 || r.Type != sample.TypeBath {
+		t.Fatalf("unexpected parsed sample: %+v", r)
+	}
+	if recs[1].Type != sample.TypeTap {
+		t.Fatalf("unexpected type: %q", recs[1].Type)
+	}
+
+	if match := p.Match(sample.TypeTap); match(recs[0]) || !match(recs[1]) {
+		t.Fatal("unexpected tap sample match")
+	}
+	if p.Match("") != nil {
+		t.Fatal("empty type should match all")
+	}
+
+	var none *Parser
+	if none.TypeOf("1234T") != "" {
+		t.Fatal("nil parser should not parse")
+	}
+}
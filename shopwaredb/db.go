@@ -1,296 +1,248 @@
 package shopwaredb
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/RoanBrand/SpectroDashboard/config"
 	"github.com/RoanBrand/SpectroDashboard/log"
 	"github.com/RoanBrand/SpectroDashboard/sample"
-	"github.com/RoanBrand/SpectroDashboard/xml_spectro/fileparser"
 	_ "github.com/denisenkom/go-mssqldb"
 )
 
 type ShopwareDB struct {
 	conf       *config.Config
+	writeMu    sync.Mutex // one write transaction at a time
+	openMu     sync.Mutex // one connection attempt at a time
+	mu         sync.Mutex // guards db
 	db         *sql.DB
 	connString string
+	table      *table
 
-	lastInsertedResultTS time.Time
-}
+	outbox *outbox
+	wake   chan struct{} // new samples in outbox
 
-func SetupShopwareDB(conf *config.Config) *ShopwareDB {
-	c := &conf.ShopwareDB
+	pingMu  sync.Mutex
+	pinging chan struct{} // closed when the ping in flight is done, nil if none
+	pingErr error         // of the last ping
+	pingAt  time.Time     // when the last ping was done
+}
 
-	sdb := &ShopwareDB{
-		conf:       conf,
-		connString: fmt.Sprintf("server=%s;user id=%s;password=%s;database=%s", c.Address, c.User, c.Password, c.Database),
-	}
+const (
+	pingCacheTTL = time.Second * 5  // result of the last ping is reused this long
+	pingTimeout  = time.Second * 30 // of a ping, if the driver gives up by then
+)
 
-	err := sdb.openDB()
+// SetupShopwareDB connects to Shopware, with samples waiting to be inserted kept in the outbox file.
+// It fails if the configured columns do not match the Shopware table. If Shopware is unreachable,
+// this is checked when it is reached.
+func SetupShopwareDB(conf *config.Config, outboxFile string) (*ShopwareDB, error) {
+	ob, err := openOutbox(outboxFile)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 
-	return sdb
-}
+	sdb := newShopwareDB(conf)
+	sdb.outbox = ob
 
-func (sdb *ShopwareDB) Stop() error {
-	if sdb.db == nil {
-		return nil
-	}
-
-	if err := sdb.db.Close(); err != nil {
-		return fmt.Errorf("failed closing shopware DB: %w", err)
+	if _, err = sdb.conn(context.Background()); err != nil {
+		var schemaErr *SchemaError
+		if errors.As(err, &schemaErr) {
+			ob.close()
+			return nil, err
+		}
+		log.Println(err)
 	}
 
-	return nil
+	return sdb, nil
 }
 
-func (sdb *ShopwareDB) openDB() error {
-	db, err := sql.Open("sqlserver", sdb.connString)
-	if err != nil {
-		return fmt.Errorf("failed opening shopware DB: %w", err)
+// Connect connects to Shopware, without an outbox for new samples, like for once off commands.
+func Connect(conf *config.Config) (*ShopwareDB, error) {
+	sdb := newShopwareDB(conf)
+	if _, err := sdb.conn(context.Background()); err != nil {
+		return nil, err
 	}
+	return sdb, nil
+}
 
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed pinging after opening shopware DB: %w", err)
+func newShopwareDB(conf *config.Config) *ShopwareDB {
+	c := &conf.ShopwareDB
+	return &ShopwareDB{
+		conf:       conf,
+		connString: fmt.Sprintf("server=%s;user id=%s;password=%s;database=%s", c.Address, c.User, c.Password, c.Database),
+		table:      newTable(c.Table, &c.Columns),
+		wake:       make(chan struct{}, 1),
 	}
-
-	sdb.db = db
-	return nil
 }
 
-// Insert new results from spectro machines into foundry's Shopware MS SQL Server database.
-func (sdb *ShopwareDB) InsertNewMDBResults(samples []*sample.Record) error {
-	if len(samples) == 0 {
+func (sdb *ShopwareDB) Stop() error {
+	if sdb == nil {
 		return nil
 	}
 
-	if !sdb.lastInsertedResultTS.IsZero() &&
-		!samples[0].TimeStamp.After(sdb.lastInsertedResultTS) {
-		// if latest sample is not newer than last inserted then nothing to do
-		return nil
-	}
+	sdb.writeMu.Lock()
+	defer sdb.writeMu.Unlock()
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
 
+	errOutbox := sdb.outbox.close()
 	if sdb.db == nil {
-		err := sdb.openDB()
-		if err != nil {
-			return err
-		}
+		return errOutbox
 	}
 
-	tx, err := sdb.db.Begin()
-	if err != nil {
-		return err
+	if err := sdb.db.Close(); err != nil {
+		return errors.Join(fmt.Errorf("failed closing shopware DB: %w", err), errOutbox)
 	}
 
-	qry := strings.Builder{}
-	qry.WriteString(`SELECT TOP (1) DateTimeStamp FROM "`)
-	qry.WriteString(sdb.conf.ShopwareDB.Table)
-	qry.WriteString(`" WHERE "Spectro" = @p1`)
-	qry.WriteString(` ORDER BY ID DESC;`)
+	return errOutbox
+}
 
-	var lastTime time.Time
-	if err = tx.QueryRow(qry.String(), sdb.conf.SpectroNumber).Scan(&lastTime); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		// We insert wall time (without TZ), so DB returns as UTC. Convert here to SAST, preserving wall clock time.
-		lastTime, err = time.ParseInLocation("2006-01-02 15:04:05", lastTime.Format("2006-01-02 15:04:05"), time.Local)
-		if err != nil {
-			return err
-		}
+// Ping checks the connection to the database, within ctx. It does not wait for writes in progress.
+// Only one ping is made at a time, and its result is shared by callers that arrive while it is made,
+// or shortly after.
+func (sdb *ShopwareDB) Ping(ctx context.Context) error {
+	sdb.pingMu.Lock()
+	if sdb.pinging == nil && time.Since(sdb.pingAt) < pingCacheTTL {
+		err := sdb.pingErr
+		sdb.pingMu.Unlock()
+		return err
+	}
+	done := sdb.pinging
+	if done == nil {
+		done = make(chan struct{})
+		sdb.pinging = done
+		go sdb.ping(done)
+	}
+	sdb.pingMu.Unlock()
 
-		sdb.lastInsertedResultTS = lastTime
-		if sdb.conf.DebugMode {
-			log.Printf("remote DB last sample timestamp: %s\n", lastTime)
-		}
+	// the driver does not give up on connecting when ctx is done, so don't wait for it.
+	select {
+	case <-done:
+		sdb.pingMu.Lock()
+		defer sdb.pingMu.Unlock()
+		return sdb.pingErr
+	case <-ctx.Done():
+		return fmt.Errorf("failed pinging shopware DB: %w", ctx.Err())
 	}
+}
 
-	extraElementsToAdd := []string{"Ni", "Mo", "Co", "Nb", "V", "W", "Mg", "Bi", "Ca", "As", "Sb", "Te", "Fe"}
+func (sdb *ShopwareDB) ping(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
 
-	for i := len(samples) - 1; i >= 0; i-- {
-		s := samples[i]
-		if !s.TimeStamp.After(lastTime) {
-			continue
-		}
+	db, err := sdb.conn(ctx)
+	if err == nil {
+		err = db.PingContext(ctx)
+	}
 
-		qry.Reset()
-		qry.WriteString(`INSERT INTO "`)
-		qry.WriteString(sdb.conf.ShopwareDB.Table)
-		qry.WriteString(`" ("DateTimeStamp", "SampleName", "Furname", "Spectro"`)
-		for _, r := range s.Results {
-			if r.Element == "" {
-				continue
-			}
-
-			qry.WriteString(`, "`)
-			qry.WriteString(r.Element)
-			qry.WriteByte('"')
-		}
-		for _, el := range extraElementsToAdd {
-			if _, ok := s.ResultsMap[el]; ok {
-				qry.WriteString(`, "`)
-				qry.WriteString(el)
-				qry.WriteByte('"')
-			}
-		}
-		qry.WriteString(`) VALUES ('`)
-		// DB column is DATETIME, with no timezone
-		qry.WriteString(s.TimeStamp.Format("2006-01-02 15:04:05"))
-		qry.WriteString("', '")
-		qry.WriteString(s.SampleName)
-		qry.WriteString("', '")
-		qry.WriteString(s.Furnace)
-		qry.WriteString("', ")
-		qry.WriteString(strconv.Itoa(sdb.conf.SpectroNumber))
-
-		for _, r := range s.Results {
-			if r.Element == "" {
-				continue
-			}
-
-			qry.WriteString(`, `)
-			qry.WriteString(strconv.FormatFloat(r.Value, 'f', 8, 64))
-		}
-		for _, el := range extraElementsToAdd {
-			if elRes, ok := s.ResultsMap[el]; ok {
-				qry.WriteString(`, `)
-				qry.WriteString(strconv.FormatFloat(elRes, 'f', 8, 64))
-			}
-		}
+	sdb.pingMu.Lock()
+	sdb.pingErr, sdb.pingAt, sdb.pinging = err, time.Now(), nil
+	sdb.pingMu.Unlock()
+	close(done)
+}
 
-		qry.WriteString(");")
+// conn returns the connection to the database, opening it within ctx if not yet open.
+func (sdb *ShopwareDB) conn(ctx context.Context) (*sql.DB, error) {
+	sdb.mu.Lock()
+	db := sdb.db
+	sdb.mu.Unlock()
+	if db != nil {
+		return db, nil
+	}
 
-		q := qry.String()
-		if sdb.conf.DebugMode {
-			log.Println("remote DB query: " + q)
-		}
-		if _, err := tx.Exec(q); err != nil {
-			tx.Rollback()
-			return errors.New("error executing insert statement: " + q + " Error: " + err.Error())
-		}
+	sdb.openMu.Lock()
+	defer sdb.openMu.Unlock()
+
+	sdb.mu.Lock()
+	db = sdb.db
+	sdb.mu.Unlock()
+	if db != nil { // opened while waiting
+		return db, nil
 	}
 
-	if err = tx.Commit(); err != nil {
-		tx.Rollback()
-		return err
+	db, err := sdb.openDB(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	sdb.lastInsertedResultTS = samples[0].TimeStamp
-	return nil
+	sdb.mu.Lock()
+	sdb.db = db
+	sdb.mu.Unlock()
+	return db, nil
 }
 
-func (sdb *ShopwareDB) InsertNewXMLResults(recs []fileparser.Record) error {
-	if len(recs) == 0 {
-		return nil
-	}
-
-	if !sdb.lastInsertedResultTS.IsZero() &&
-		!recs[0].TimeStamp.After(sdb.lastInsertedResultTS) {
-		// if latest result is not newer than last inserted then nothing to do
-		return nil
+func (sdb *ShopwareDB) openDB(ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open("sqlserver", sdb.connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening shopware DB: %w", err)
 	}
 
-	if sdb.db == nil {
-		err := sdb.openDB()
-		if err != nil {
-			return err
-		}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed pinging after opening shopware DB: %w", err)
 	}
 
-	tx, err := sdb.db.Begin()
-	if err != nil {
-		return err
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	if err = sdb.table.validate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	qry := strings.Builder{}
-	qry.WriteString(`SELECT TOP (1) DateTimeStamp FROM "`)
-	qry.WriteString(sdb.conf.ShopwareDB.Table)
-	qry.WriteString(`" WHERE "Spectro" = @p1`)
-	qry.WriteString(` ORDER BY ID DESC;`)
-
-	var lastTime time.Time
-	if err = tx.QueryRow(qry.String(), sdb.conf.SpectroNumber).Scan(&lastTime); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		// We insert wall time (without TZ), so DB returns as UTC. Convert here to SAST, preserving wall clock time.
-		lastTime, err = time.ParseInLocation("2006-01-02 15:04:05", lastTime.Format("2006-01-02 15:04:05"), time.Local)
-		if err != nil {
-			return err
-		}
+	return db, nil
+}
 
-		sdb.lastInsertedResultTS = lastTime
-		if sdb.conf.DebugMode {
-			log.Printf("remote DB last sample timestamp: %s\n", lastTime)
-		}
-	}
+// sampleError is an error writing a specific sample.
+type sampleError struct {
+	rec *sample.Record
+	err error
+}
 
-	elementsToInsert := []string{"C", "Si", "Mn", "P", "S", "Cu", "Cr", "Al", "Ti", "Sn", "Zn", "Pb",
-		"Ni", "Mo", "Co", "Nb", "V", "W", "Mg", "Bi", "Ca", "As", "Sb", "Te", "Fe"}
+func (e *sampleError) Error() string { return e.err.Error() }
+func (e *sampleError) Unwrap() error { return e.err }
 
-	for i := len(recs) - 1; i >= 0; i-- { // reverse order: older to newer
-		s := &recs[i]
-		if !s.TimeStamp.After(lastTime) {
-			continue
-		}
+// writeSamples writes samples of a spectro, oldest first, into foundry's Shopware MS SQL Server database.
+// Samples already in Shopware are updated, so writing samples again is safe.
+// Returns the samples that were new. All samples are written, or none on error.
+func (sdb *ShopwareDB) writeSamples(spectro int, samples []*sample.Record) (inserted []*sample.Record, err error) {
+	sdb.writeMu.Lock()
+	defer sdb.writeMu.Unlock()
 
-		qry.Reset()
-		qry.WriteString(`INSERT INTO "`)
-		qry.WriteString(sdb.conf.ShopwareDB.Table)
-		qry.WriteString(`" ("DateTimeStamp", "SampleName", "Furname", "Spectro"`)
-
-		for _, el := range elementsToInsert {
-			if _, ok := s.Results[el]; ok {
-				qry.WriteString(`, "`)
-				qry.WriteString(el)
-				qry.WriteByte('"')
-			}
-		}
-		qry.WriteString(`) VALUES ('`)
-		// DB column is DATETIME, with no timezone
-		qry.WriteString(s.TimeStamp.Format("2006-01-02 15:04:05"))
-		qry.WriteString("', '")
-		qry.WriteString(s.ID)
-		qry.WriteString("', '")
-		qry.WriteString(s.Furnace)
-		qry.WriteString("', ")
-		qry.WriteString(strconv.Itoa(sdb.conf.SpectroNumber))
-
-		for _, el := range elementsToInsert {
-			if elRes, ok := s.Results[el]; ok {
-				qry.WriteString(`, `)
-				qry.WriteString(strconv.FormatFloat(elRes, 'f', 8, 64))
-			}
-		}
+	db, err := sdb.conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
 
-		qry.WriteString(");")
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
 
-		q := qry.String()
+	for _, s := range samples {
+		q, args := sdb.table.merge(spectro, s)
 		if sdb.conf.DebugMode {
-			log.Println("remote DB query: " + q)
+			log.Println("remote DB query:", q, args)
 		}
-		if _, err := tx.Exec(q); err != nil {
+
+		var action string
+		if err := tx.QueryRow(q, args...).Scan(&action); err != nil {
 			tx.Rollback()
-			return errors.New("error executing insert statement: " + q + " Error: " + err.Error())
+			return nil, &sampleError{rec: s, err: fmt.Errorf("error writing sample %s of spectro %d: %w", s.SampleName, spectro, err)}
+		}
+		if action == "INSERT" {
+			inserted = append(inserted, s)
 		}
 	}
 
 	if err = tx.Commit(); err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	sdb.lastInsertedResultTS = recs[0].TimeStamp
-	return nil
+	return inserted, nil
 }
@@ -0,0 +1,60 @@
+package shopwaredb
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+)
+
+func TestPingTimeout(t *testing.T) {
+	// server that accepts connections but never responds
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			defer c.Close()
+		}
+	}()
+
+	sdb := newShopwareDB(&config.Config{})
+	sdb.connString = "sqlserver://user:password@" + l.Addr().String() + "?database=Shopware"
+
+	// a write in progress does not hold up pings
+	sdb.writeMu.Lock()
+	defer sdb.writeMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	start := time.Now()
+	if err = sdb.Ping(ctx); err == nil {
+		t.Fatal("expected ping to fail")
+	}
+	if d := time.Since(start); d > time.Second*2 {
+		t.Fatalf("ping took %s", d)
+	}
+
+	// pings while one is in flight share it
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		if err = sdb.Ping(ctx); err == nil {
+			t.Fatal("expected ping to fail")
+		}
+		cancel()
+	}
+	if n := atomic.LoadInt32(&accepted); n > 1 {
+		t.Fatalf("expected one connection attempt, got %d", n)
+	}
+}
@@ -0,0 +1,446 @@
+package shopwaredb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/metrics"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pendingBucket = []byte("pending") // key -> pending sample, ordered by time
+	sentBucket    = []byte("sent")    // key -> time inserted
+	deadBucket    = []byte("dead")    // key -> sample Shopware rejected maxSampleFailures times, not retried
+)
+
+const (
+	outboxBatchSize    = 100
+	outboxPollInterval = time.Minute
+	sentRetention      = time.Hour * 24 * 30 // remember inserted samples, so they are not queued again
+	minRetryDelay      = time.Second
+	maxRetryDelay      = time.Minute * 5
+	maxSampleFailures  = 10 // inserts of a sample that failed, before it stops holding up its spectro
+)
+
+// outbox is a persistent queue of samples waiting to be inserted into Shopware.
+// A sample is identified by its measurement time, spectro and sample name.
+type outbox struct {
+	db *bolt.DB
+}
+
+type pendingSample struct {
+	SampleName string             `json:"sample_name"`
+	Furnace    string             `json:"furnace"`
+	TimeStamp  time.Time          `json:"time_stamp"`
+	Spectro    int                `json:"spectro"`
+	Results    map[string]float64 `json:"results"`
+	Heat       string             `json:"heat,omitempty"`
+	Sequence   int                `json:"sequence,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Shift      string             `json:"shift,omitempty"`
+	Failures   int                `json:"failures,omitempty"`
+}
+
+func openOutbox(path string) (*outbox, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, fmt.Errorf("failed opening shopware outbox %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{pendingBucket, sentBucket, deadBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed creating shopware outbox buckets: %w", err)
+	}
+
+	return &outbox{db: db}, nil
+}
+
+func (o *outbox) close() error {
+	if o == nil {
+		return nil
+	}
+	if err := o.db.Close(); err != nil {
+		return fmt.Errorf("failed closing shopware outbox: %w", err)
+	}
+	return nil
+}
+
+// add queues samples not already pending, inserted or dead. Returns number queued.
+func (o *outbox) add(recs []*sample.Record) (int, error) {
+	added := 0
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		pending, sent, dead := tx.Bucket(pendingBucket), tx.Bucket(sentBucket), tx.Bucket(deadBucket)
+		for _, r := range recs {
+			k := outboxKey(r)
+			if pending.Get(k) != nil || sent.Get(k) != nil || dead.Get(k) != nil {
+				continue
+			}
+
+			v, err := json.Marshal(&pendingSample{
+				SampleName: r.SampleName,
+				Furnace:    r.Furnace,
+				TimeStamp:  r.TimeStamp,
+				Spectro:    r.Spectro,
+				Results:    r.ResultsMap,
+				Heat:       r.Heat,
+				Sequence:   r.Sequence,
+				Type:       r.Type,
+				Shift:      r.Shift,
+			})
+			if err != nil {
+				return err
+			}
+			if err = pending.Put(k, v); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed adding samples to shopware outbox: %w", err)
+	}
+	return added, nil
+}
+
+// oldest returns up to n pending samples and their keys, oldest first.
+func (o *outbox) oldest(n int) (keys [][]byte, recs []*sample.Record, err error) {
+	err = o.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(pendingBucket).Cursor()
+		for k, v := c.First(); k != nil && len(recs) < n; k, v = c.Next() {
+			var ps pendingSample
+			if err := json.Unmarshal(v, &ps); err != nil {
+				return fmt.Errorf("failed decoding sample from shopware outbox: %w", err)
+			}
+
+			keys = append(keys, append([]byte(nil), k...))
+			recs = append(recs, &sample.Record{
+				SampleName: ps.SampleName,
+				Furnace:    ps.Furnace,
+				TimeStamp:  ps.TimeStamp.Local(),
+				Spectro:    ps.Spectro,
+				ResultsMap: ps.Results,
+				Heat:       ps.Heat,
+				Sequence:   ps.Sequence,
+				Type:       ps.Type,
+				Shift:      ps.Shift,
+			})
+		}
+		return nil
+	})
+	return
+}
+
+// markSent moves samples from pending to sent.
+func (o *outbox) markSent(keys [][]byte, at time.Time) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(at.UnixNano()))
+
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		pending, sent := tx.Bucket(pendingBucket), tx.Bucket(sentBucket)
+		for _, k := range keys {
+			if err := pending.Delete(k); err != nil {
+				return err
+			}
+			if err := sent.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed marking samples sent in shopware outbox: %w", err)
+	}
+	return nil
+}
+
+// failed counts a failed insert of a pending sample. After maxSampleFailures
+// the sample is moved to dead and returns true.
+func (o *outbox) failed(k []byte) (dead bool, err error) {
+	err = o.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		v := pending.Get(k)
+		if v == nil {
+			return nil
+		}
+
+		var ps pendingSample
+		if err := json.Unmarshal(v, &ps); err != nil {
+			return err
+		}
+		ps.Failures++
+		if v, err = json.Marshal(&ps); err != nil {
+			return err
+		}
+
+		if ps.Failures < maxSampleFailures {
+			return pending.Put(k, v)
+		}
+		if err := pending.Delete(k); err != nil {
+			return err
+		}
+		dead = true
+		return tx.Bucket(deadBucket).Put(k, v)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed counting failed insert in shopware outbox: %w", err)
+	}
+	return
+}
+
+// prune forgets samples inserted before t.
+func (o *outbox) prune(t time.Time) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		sent := tx.Bucket(sentBucket)
+		var old [][]byte
+		sent.ForEach(func(k, v []byte) error {
+			if len(v) != 8 || int64(binary.BigEndian.Uint64(v)) < t.UnixNano() {
+				old = append(old, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range old {
+			if err := sent.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pending returns the number of samples waiting to be inserted.
+func (o *outbox) pending() (n int) {
+	o.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(pendingBucket).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// dead returns the number of samples that are not retried anymore.
+func (o *outbox) dead() (n int) {
+	o.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(deadBucket).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// outboxKey is the measurement time, spectro and sample name. Keys sort by time.
+func outboxKey(r *sample.Record) []byte {
+	k := make([]byte, 12, 12+len(r.SampleName))
+	binary.BigEndian.PutUint64(k, uint64(r.TimeStamp.UnixNano()))
+	binary.BigEndian.PutUint32(k[8:], uint32(r.Spectro))
+	return append(k, r.SampleName...)
+}
+
+// Enqueue queues new samples to be inserted into Shopware.
+// Samples already queued or inserted are ignored.
+func (sdb *ShopwareDB) Enqueue(samples []*sample.Record) error {
+	added, err := sdb.outbox.add(samples)
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return nil
+	}
+
+	sdb.observeOutbox()
+	select {
+	case sdb.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending returns the number of samples waiting to be inserted into Shopware,
+// and the measurement time of the oldest.
+func (sdb *ShopwareDB) Pending() (n int, oldest time.Time) {
+	if n = sdb.outbox.pending(); n == 0 {
+		return 0, time.Time{}
+	}
+	_, recs, err := sdb.outbox.oldest(1)
+	if err != nil || len(recs) == 0 {
+		return n, time.Time{}
+	}
+	return n, recs[0].TimeStamp
+}
+
+// Dead returns the number of samples Shopware rejected too often to be retried.
+// They are kept in the outbox's dead bucket.
+func (sdb *ShopwareDB) Dead() int {
+	return sdb.outbox.dead()
+}
+
+func (sdb *ShopwareDB) observeOutbox() {
+	metrics.ShopwareOutboxPending.Set(float64(sdb.outbox.pending()))
+	metrics.ShopwareOutboxDead.Set(float64(sdb.outbox.dead()))
+}
+
+// Run inserts queued samples into Shopware until ctx is done.
+// Failed inserts are retried with exponential backoff, also after a restart.
+// A sample that fails maxSampleFailures times is moved to dead, so it does not hold up its spectro's queue.
+func (sdb *ShopwareDB) Run(ctx context.Context) {
+	sdb.observeOutbox()
+
+	failures := 0
+	t := time.NewTimer(0)
+	defer t.Stop()
+
+	for {
+		// don't cut a retry delay short for new samples
+		var wake <-chan struct{}
+		if failures == 0 {
+			wake = sdb.wake
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			if !t.Stop() {
+				<-t.C
+			}
+		case <-t.C:
+		}
+
+		delay := outboxPollInterval
+		if err := sdb.flush(); err != nil {
+			failures++
+			delay = retryDelay(failures)
+			log.Printf("failed inserting samples into Shopware (%d pending), retrying in %s: %v\n", sdb.outbox.pending(), delay, err)
+		} else {
+			failures = 0
+			if err = sdb.outbox.prune(time.Now().Add(-sentRetention)); err != nil {
+				log.Println("failed pruning shopware outbox:", err)
+			}
+		}
+
+		t.Reset(delay)
+	}
+}
+
+// flush inserts all pending samples, oldest first.
+func (sdb *ShopwareDB) flush() error {
+	defer sdb.observeOutbox()
+
+	for {
+		keys, recs, err := sdb.outbox.oldest(outboxBatchSize)
+		if err != nil || len(recs) == 0 {
+			return err
+		}
+
+		bySpectro := make(map[int][]int)
+		for i, r := range recs {
+			bySpectro[r.Spectro] = append(bySpectro[r.Spectro], i)
+		}
+		spectros := make([]int, 0, len(bySpectro))
+		for spectro := range bySpectro {
+			spectros = append(spectros, spectro)
+		}
+		sort.Ints(spectros)
+
+		var sent [][]byte
+		var errs []error
+		for _, spectro := range spectros {
+			samples := make([]*sample.Record, len(bySpectro[spectro]))
+			for j, i := range bySpectro[spectro] {
+				samples[j] = recs[i]
+			}
+
+			inserted, err := sdb.writeSamples(spectro, samples)
+			observeInsert(spectro, inserted, err)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("spectro %d: %w", spectro, err))
+				if err = sdb.sampleFailed(err, keys, recs, bySpectro[spectro]); err != nil {
+					errs = append(errs, err)
+				}
+				continue
+			}
+
+			for _, i := range bySpectro[spectro] {
+				sent = append(sent, keys[i])
+			}
+		}
+
+		if err = sdb.outbox.markSent(sent, time.Now()); err != nil {
+			return err
+		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+		if len(recs) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// sampleFailed counts the failed insert of the sample that err is about, if any, of samples at indices.
+func (sdb *ShopwareDB) sampleFailed(err error, keys [][]byte, recs []*sample.Record, indices []int) error {
+	var se *sampleError
+	if !errors.As(err, &se) {
+		return nil
+	}
+
+	for _, i := range indices {
+		if recs[i] != se.rec {
+			continue
+		}
+		dead, err := sdb.outbox.failed(keys[i])
+		if dead {
+			log.Printf("giving up on inserting sample %s of spectro %d from %s into Shopware after %d failures, moved to dead in outbox\n",
+				se.rec.SampleName, se.rec.Spectro, se.rec.TimeStamp.Format(time.RFC3339), maxSampleFailures)
+		}
+		return err
+	}
+	return nil
+}
+
+func observeInsert(spectro int, inserted []*sample.Record, err error) {
+	spectroLabel := metrics.Spectro(spectro)
+	if err != nil {
+		metrics.ShopwareInserts.WithLabelValues(spectroLabel, "failure").Inc()
+		return
+	}
+	if len(inserted) == 0 {
+		return
+	}
+
+	metrics.ShopwareInserts.WithLabelValues(spectroLabel, "success").Inc()
+	metrics.ShopwareInsertedSamples.WithLabelValues(spectroLabel).Add(float64(len(inserted)))
+	now := time.Now()
+	for _, s := range inserted {
+		metrics.ShopwareInsertLag.WithLabelValues(spectroLabel).Observe(now.Sub(s.TimeStamp).Seconds())
+	}
+}
+
+func retryDelay(failures int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < failures && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
@@ -0,0 +1,151 @@
+package shopwaredb
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	ob, err := openOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Date(2023, 5, 1, 8, 0, 0, 0, time.Local)
+	recs := []*sample.Record{
+		{SampleName: "A2", Furnace: "A", TimeStamp: t0.Add(time.Minute * 2), Spectro: 1, ResultsMap: map[string]float64{"C": 3.5}},
+		{SampleName: "B1", Furnace: "B", TimeStamp: t0.Add(time.Minute), Spectro: 2},
+		{SampleName: "A1", Furnace: "A", TimeStamp: t0, Spectro: 1},
+	}
+
+	if n, err := ob.add(recs); err != nil || n != 3 {
+		t.Fatalf("add: %d %v", n, err)
+	}
+	if n, err := ob.add(recs[:1]); err != nil || n != 0 {
+		t.Fatalf("add pending again: %d %v", n, err)
+	}
+
+	// survives restart
+	if err = ob.close(); err != nil {
+		t.Fatal(err)
+	}
+	if ob, err = openOutbox(path); err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+
+	keys, got, err := ob.oldest(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].SampleName != "A1" || got[1].SampleName != "B1" {
+		t.Fatalf("oldest: %+v", got)
+	}
+
+	if err = ob.markSent(keys, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := ob.pending(); n != 1 {
+		t.Fatalf("pending after sent: %d", n)
+	}
+	if n, _ := ob.add(recs); n != 0 {
+		t.Fatalf("add sent again: %d", n)
+	}
+
+	_, got, _ = ob.oldest(10)
+	if len(got) != 1 || got[0].SampleName != "A2" || got[0].ResultsMap["C"] != 3.5 || !got[0].TimeStamp.Equal(recs[0].TimeStamp) {
+		t.Fatalf("remaining: %+v", got)
+	}
+
+	if err = ob.prune(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := ob.add(recs[1:]); n != 2 {
+		t.Fatalf("add after prune: %d", n)
+	}
+}
+
+func TestOutboxFields(t *testing.T) {
+	ob, err := openOutbox(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+
+	r := &sample.Record{
+		SampleName: "1234-2T", Furnace: "F1", TimeStamp: time.Date(2023, 5, 1, 8, 30, 15, 0, time.Local), Spectro: 1,
+		ResultsMap: map[string]float64{"C": 3.41}, Heat: "1234", Sequence: 2, Type: sample.TypeTap, Shift: "B",
+	}
+	if _, err = ob.add([]*sample.Record{r}); err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := ob.oldest(1)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("oldest: %+v %v", got, err)
+	}
+
+	// fields parsed from sample names are written from the outbox
+	tbl := newTable("Spectro", &config.ShopwareColumns{
+		TimeStamp: "DateTimeStamp", SampleName: "SampleName", Furnace: "Furname", Spectro: "Spectro",
+		Heat: "Heat", Sequence: "Seq", Type: "SampleType", Shift: "Shift", Elements: map[string]string{"C": "C"},
+	})
+	_, args := tbl.merge(1, got[0])
+	want := []interface{}{"2023-05-01T08:30:15", "1234-2T", "F1", 1, "1234", 2, sample.TypeTap, "B", 3.41}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("merge args: got %v, want %v", args, want)
+	}
+}
+
+func TestOutboxDead(t *testing.T) {
+	ob, err := openOutbox(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+
+	t0 := time.Date(2023, 5, 1, 8, 0, 0, 0, time.Local)
+	recs := []*sample.Record{
+		{SampleName: "BAD", TimeStamp: t0, Spectro: 1},
+		{SampleName: "A1", TimeStamp: t0.Add(time.Minute), Spectro: 1},
+	}
+	if _, err = ob.add(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	k := outboxKey(recs[0])
+	for i := 1; i <= maxSampleFailures; i++ {
+		dead, err := ob.failed(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dead != (i == maxSampleFailures) {
+			t.Fatalf("failure %d: dead %v", i, dead)
+		}
+	}
+
+	if ob.pending() != 1 || ob.dead() != 1 {
+		t.Fatalf("expected 1 pending and 1 dead, got %d and %d", ob.pending(), ob.dead())
+	}
+	if _, got, _ := ob.oldest(10); len(got) != 1 || got[0].SampleName != "A1" {
+		t.Fatalf("oldest: %+v", got)
+	}
+	if n, _ := ob.add(recs[:1]); n != 0 {
+		t.Fatalf("add dead again: %d", n)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1: time.Second, 2: time.Second * 2, 4: time.Second * 8, 20: maxRetryDelay,
+	} {
+		if got := retryDelay(failures); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package shopwaredb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// Results in Shopware may be stored with fewer decimals than the spectro reports.
+const valueTolerance = 0.0001
+
+// Difference between a spectro's samples at the source and in Shopware, over a time range.
+type Difference struct {
+	Spectro    int
+	Matched    int
+	Missing    []*sample.Record // at source, not in Shopware
+	Extra      []*sample.Record // in Shopware, not at source
+	Mismatched []Mismatch
+}
+
+// Mismatch is a sample in both, with different furnace or results.
+type Mismatch struct {
+	Source, Shopware *sample.Record
+	Fields           []string // furnace and/or elements that differ
+}
+
+// Compare a spectro's samples from a source, measured from, up to but excluding, to, with those in Shopware.
+func (sdb *ShopwareDB) Compare(spectro int, from, to time.Time, source []*sample.Record) (*Difference, error) {
+	shopware, err := sdb.samplesBetween(spectro, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return diff(spectro, source, shopware, sdb.table.elements), nil
+}
+
+// Repair writes the missing and mismatched samples to Shopware. Extra samples are left alone.
+// Returns the number of samples written.
+func (sdb *ShopwareDB) Repair(d *Difference) (int, error) {
+	samples := d.ToWrite()
+	if len(samples) == 0 {
+		return 0, nil
+	}
+
+	if _, err := sdb.Write(d.Spectro, samples); err != nil {
+		return 0, err
+	}
+	return len(samples), nil
+}
+
+// ToWrite returns the missing and mismatched samples at source, in time order.
+func (d *Difference) ToWrite() []*sample.Record {
+	samples := make([]*sample.Record, 0, len(d.Missing)+len(d.Mismatched))
+	samples = append(samples, d.Missing...)
+	for _, m := range d.Mismatched {
+		samples = append(samples, m.Source)
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].TimeStamp.Before(samples[j].TimeStamp)
+	})
+	return samples
+}
+
+// Write samples of a spectro straight to Shopware, bypassing the outbox, like Repair.
+// Returns the samples that were new.
+func (sdb *ShopwareDB) Write(spectro int, samples []*sample.Record) ([]*sample.Record, error) {
+	inserted, err := sdb.writeSamples(spectro, samples)
+	observeInsert(spectro, inserted, err)
+	return inserted, err
+}
+
+// samplesBetween reads a spectro's samples from Shopware, in time order.
+func (sdb *ShopwareDB) samplesBetween(spectro int, from, to time.Time) ([]*sample.Record, error) {
+	db, err := sdb.conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	q, args := sdb.table.selectBetween(spectro, from, to)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading samples of spectro %d from shopware: %w", spectro, err)
+	}
+	defer rows.Close()
+
+	var recs []*sample.Record
+	for rows.Next() {
+		var ts time.Time
+		var name, furnace sql.NullString
+		values := make([]sql.NullFloat64, len(sdb.table.elements))
+
+		dest := []interface{}{&ts, &name, &furnace}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed reading samples of spectro %d from shopware: %w", spectro, err)
+		}
+
+		// We insert wall time (without TZ), so DB returns as UTC. Convert here to SAST, preserving wall clock time.
+		r := &sample.Record{
+			SampleName: name.String,
+			Furnace:    furnace.String,
+			TimeStamp:  time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.Local),
+			Spectro:    spectro,
+			ResultsMap: make(map[string]float64, len(values)),
+		}
+		for i, v := range values {
+			if v.Valid {
+				r.ResultsMap[sdb.table.elements[i].element] = v.Float64
+			}
+		}
+		recs = append(recs, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading samples of spectro %d from shopware: %w", spectro, err)
+	}
+
+	return recs, nil
+}
+
+// diff matches samples by sample name and measurement time to the second, like they are stored in Shopware.
+// Only the elements written to Shopware are compared.
+func diff(spectro int, source, shopware []*sample.Record, elements []elementColumn) *Difference {
+	d := &Difference{Spectro: spectro}
+
+	inShopware := make(map[string]*sample.Record, len(shopware))
+	var duplicates []*sample.Record
+	for _, r := range shopware {
+		if _, ok := inShopware[diffKey(r)]; ok {
+			duplicates = append(duplicates, r)
+			continue
+		}
+		inShopware[diffKey(r)] = r
+	}
+
+	for _, src := range source {
+		k := diffKey(src)
+		sw, ok := inShopware[k]
+		if !ok {
+			d.Missing = append(d.Missing, src)
+			continue
+		}
+		delete(inShopware, k)
+
+		var fields []string
+		if src.Furnace != sw.Furnace {
+			fields = append(fields, "furnace")
+		}
+		for _, ec := range elements {
+			v, ok := src.ResultsMap[ec.element]
+			if !ok {
+				continue
+			}
+			if swV, ok := sw.ResultsMap[ec.element]; !ok || math.Abs(v-swV) > valueTolerance {
+				fields = append(fields, ec.element)
+			}
+		}
+
+		if len(fields) == 0 {
+			d.Matched++
+		} else {
+			d.Mismatched = append(d.Mismatched, Mismatch{Source: src, Shopware: sw, Fields: fields})
+		}
+	}
+
+	for _, r := range shopware {
+		if inShopware[diffKey(r)] == r {
+			d.Extra = append(d.Extra, r)
+		}
+	}
+	d.Extra = append(d.Extra, duplicates...)
+
+	return d
+}
+
+func diffKey(r *sample.Record) string {
+	return r.TimeStamp.Format("2006-01-02 15:04:05") + "\x00" + r.SampleName
+}
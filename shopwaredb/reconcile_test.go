@@ -0,0 +1,49 @@
+package shopwaredb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestDiff(t *testing.T) {
+	tbl := newTable("Spectro", &config.ShopwareColumns{Elements: map[string]string{"C": "C", "Si": "Si"}})
+	t0 := time.Date(2023, 5, 1, 8, 0, 0, 0, time.Local)
+
+	source := []*sample.Record{
+		{SampleName: "A1", Furnace: "F1", TimeStamp: t0.Add(time.Millisecond * 300), ResultsMap: map[string]float64{"C": 3.41234, "Si": 2.1, "Cu": 0.5}},
+		{SampleName: "A2", Furnace: "F1", TimeStamp: t0.Add(time.Minute), ResultsMap: map[string]float64{"C": 3.5, "Si": 2.2}},
+		{SampleName: "A3", Furnace: "F1", TimeStamp: t0.Add(time.Minute * 2), ResultsMap: map[string]float64{"C": 3.6}},
+		{SampleName: "A4", Furnace: "F2", TimeStamp: t0.Add(time.Minute * 3), ResultsMap: map[string]float64{"C": 3.7}},
+	}
+	shopware := []*sample.Record{
+		{SampleName: "A1", Furnace: "F1", TimeStamp: t0, ResultsMap: map[string]float64{"C": 3.4123, "Si": 2.1}},
+		{SampleName: "A2", Furnace: "F1", TimeStamp: t0.Add(time.Minute), ResultsMap: map[string]float64{"C": 3.5}},
+		{SampleName: "A4", Furnace: "F1", TimeStamp: t0.Add(time.Minute * 3), ResultsMap: map[string]float64{"C": 3.9}},
+		{SampleName: "B1", Furnace: "F2", TimeStamp: t0.Add(time.Minute * 4)},
+		{SampleName: "A1", Furnace: "F1", TimeStamp: t0, ResultsMap: map[string]float64{"C": 3.4123, "Si": 2.1}},
+	}
+
+	d := diff(2, source, shopware, tbl.elements)
+	if d.Spectro != 2 || d.Matched != 1 {
+		t.Errorf("matched: %+v", d)
+	}
+	if len(d.Missing) != 1 || d.Missing[0].SampleName != "A3" {
+		t.Errorf("missing: %+v", d.Missing)
+	}
+	if len(d.Extra) != 2 || d.Extra[0].SampleName != "B1" || d.Extra[1].SampleName != "A1" {
+		t.Errorf("extra: %+v", d.Extra)
+	}
+	if len(d.Mismatched) != 2 {
+		t.Fatalf("mismatched: %+v", d.Mismatched)
+	}
+	if m := d.Mismatched[0]; m.Source.SampleName != "A2" || !reflect.DeepEqual(m.Fields, []string{"Si"}) {
+		t.Errorf("mismatched A2: %+v", m)
+	}
+	if m := d.Mismatched[1]; m.Source.SampleName != "A4" || !reflect.DeepEqual(m.Fields, []string{"furnace", "C"}) {
+		t.Errorf("mismatched A4: %+v", m)
+	}
+}
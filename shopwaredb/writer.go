@@ -0,0 +1,251 @@
+package shopwaredb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+// table is the Shopware table samples are written to, with its configured column names.
+type table struct {
+	name                                    string
+	timeStamp, sampleName, furnace, spectro string
+	fields                                  []fieldColumn   // configured only
+	elements                                []elementColumn // sorted by element
+}
+
+type elementColumn struct {
+	element, column string
+}
+
+// fieldColumn is an optional column of a field parsed from sample names.
+type fieldColumn struct {
+	column string
+	value  func(s *sample.Record) interface{} // nil if not parsed
+}
+
+// SchemaError is a column mapping that does not match the Shopware table.
+type SchemaError struct {
+	Table   string
+	Missing []string // columns, or nil if the table itself is missing
+}
+
+func (e *SchemaError) Error() string {
+	if e.Missing == nil {
+		return fmt.Sprintf("shopware table %s not found", e.Table)
+	}
+	return fmt.Sprintf("shopware table %s has no columns %s", e.Table, strings.Join(e.Missing, ", "))
+}
+
+func newTable(name string, c *config.ShopwareColumns) *table {
+	t := &table{
+		name:       name,
+		timeStamp:  c.TimeStamp,
+		sampleName: c.SampleName,
+		furnace:    c.Furnace,
+		spectro:    c.Spectro,
+		elements:   make([]elementColumn, 0, len(c.Elements)),
+	}
+
+	for _, fc := range []fieldColumn{
+		{c.Heat, func(s *sample.Record) interface{} { return nullIfEmpty(s.Heat) }},
+		{c.Sequence, func(s *sample.Record) interface{} {
+			if s.Sequence == 0 {
+				return nil
+			}
+			return s.Sequence
+		}},
+		{c.Type, func(s *sample.Record) interface{} { return nullIfEmpty(s.Type) }},
+		{c.Shift, func(s *sample.Record) interface{} { return nullIfEmpty(s.Shift) }},
+	} {
+		if fc.column != "" {
+			t.fields = append(t.fields, fc)
+		}
+	}
+
+	for el, col := range c.Elements {
+		t.elements = append(t.elements, elementColumn{element: el, column: col})
+	}
+	sort.Slice(t.elements, func(i, j int) bool {
+		return t.elements[i].element < t.elements[j].element
+	})
+
+	return t
+}
+
+// validate checks that the table and all mapped columns exist.
+func (t *table) validate(ctx context.Context, db *sql.DB) error {
+	schema, name := "", t.name
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		schema, name = name[:i], name[i+1:]
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_NAME = @p1 AND (@p2 = '' OR TABLE_SCHEMA = @p2);`, name, schema)
+	if err != nil {
+		return fmt.Errorf("failed reading shopware table schema: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var col string
+		if err = rows.Scan(&col); err != nil {
+			return fmt.Errorf("failed reading shopware table schema: %w", err)
+		}
+		existing[strings.ToUpper(col)] = true
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed reading shopware table schema: %w", err)
+	}
+
+	if len(existing) == 0 {
+		return &SchemaError{Table: t.name}
+	}
+
+	var missing []string
+	for _, col := range t.columns() {
+		if !existing[strings.ToUpper(col)] {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) > 0 {
+		return &SchemaError{Table: t.name, Missing: missing}
+	}
+
+	return nil
+}
+
+func (t *table) columns() []string {
+	cols := []string{t.timeStamp, t.sampleName, t.furnace, t.spectro}
+	for _, fc := range t.fields {
+		cols = append(cols, fc.column)
+	}
+	for _, ec := range t.elements {
+		cols = append(cols, ec.column)
+	}
+	return cols
+}
+
+// merge returns the statement and its arguments that inserts a sample, or updates it if already in the table.
+// A sample is identified by spectro, sample name and measurement time, so writing it again is safe.
+// Only elements in the sample's results are written. The statement outputs "INSERT" or "UPDATE".
+func (t *table) merge(spectro int, s *sample.Record) (string, []interface{}) {
+	cols := []string{quoteIdent(t.timeStamp), quoteIdent(t.sampleName), quoteIdent(t.furnace), quoteIdent(t.spectro)}
+	// DB column is DATETIME, with no timezone. ISO 8601 is independent of the server's date format.
+	args := []interface{}{s.TimeStamp.Format("2006-01-02T15:04:05"), s.SampleName, s.Furnace, spectro}
+	for _, fc := range t.fields {
+		cols = append(cols, quoteIdent(fc.column))
+		args = append(args, fc.value(s))
+	}
+	for _, ec := range t.elements {
+		if v, ok := s.ResultsMap[ec.element]; ok {
+			cols = append(cols, quoteIdent(ec.column))
+			args = append(args, v)
+		}
+	}
+
+	qry := strings.Builder{}
+	qry.WriteString("MERGE INTO ")
+	qry.WriteString(quoteIdent(t.name))
+	qry.WriteString(" WITH (HOLDLOCK) AS t USING (VALUES (CONVERT(DATETIME2(0), @p1, 126)")
+	for i := 2; i <= len(args); i++ {
+		qry.WriteString(", @p")
+		qry.WriteString(strconv.Itoa(i))
+	}
+	qry.WriteString(")) AS s (")
+	qry.WriteString(strings.Join(cols, ", "))
+
+	qry.WriteString(") ON t.")
+	qry.WriteString(cols[3])
+	qry.WriteString(" = s.")
+	qry.WriteString(cols[3])
+	qry.WriteString(" AND t.")
+	qry.WriteString(cols[1])
+	qry.WriteString(" = s.")
+	qry.WriteString(cols[1])
+	qry.WriteString(" AND t.")
+	qry.WriteString(cols[0])
+	qry.WriteString(" = s.")
+	qry.WriteString(cols[0])
+
+	qry.WriteString(" WHEN MATCHED THEN UPDATE SET ")
+	for i, c := range append([]string{cols[2]}, cols[4:]...) { // all but the key
+		if i > 0 {
+			qry.WriteString(", ")
+		}
+		qry.WriteString(c)
+		qry.WriteString(" = s.")
+		qry.WriteString(c)
+	}
+
+	qry.WriteString(" WHEN NOT MATCHED THEN INSERT (")
+	qry.WriteString(strings.Join(cols, ", "))
+	qry.WriteString(") VALUES (s.")
+	qry.WriteString(strings.Join(cols, ", s."))
+	qry.WriteString(") OUTPUT $action;")
+
+	return qry.String(), args
+}
+
+// selectBetween returns the query and its arguments that selects the timestamp, sample name, furnace
+// and element columns of a spectro's samples measured from, up to but excluding, to, in time order.
+// Zero to is unbounded.
+func (t *table) selectBetween(spectro int, from, to time.Time) (string, []interface{}) {
+	qry := strings.Builder{}
+	qry.WriteString("SELECT ")
+	qry.WriteString(quoteIdent(t.timeStamp))
+	qry.WriteString(", ")
+	qry.WriteString(quoteIdent(t.sampleName))
+	qry.WriteString(", ")
+	qry.WriteString(quoteIdent(t.furnace))
+	for _, ec := range t.elements {
+		qry.WriteString(", ")
+		qry.WriteString(quoteIdent(ec.column))
+	}
+
+	qry.WriteString(" FROM ")
+	qry.WriteString(quoteIdent(t.name))
+	qry.WriteString(" WHERE ")
+	qry.WriteString(quoteIdent(t.spectro))
+	qry.WriteString(" = @p1 AND ")
+	qry.WriteString(quoteIdent(t.timeStamp))
+	qry.WriteString(" >= CONVERT(DATETIME2(0), @p2, 126)")
+
+	args := []interface{}{spectro, from.Format("2006-01-02T15:04:05")}
+	if !to.IsZero() {
+		qry.WriteString(" AND ")
+		qry.WriteString(quoteIdent(t.timeStamp))
+		qry.WriteString(" < CONVERT(DATETIME2(0), @p3, 126)")
+		args = append(args, to.Format("2006-01-02T15:04:05"))
+	}
+
+	qry.WriteString(" ORDER BY ")
+	qry.WriteString(quoteIdent(t.timeStamp))
+	qry.WriteString(";")
+
+	return qry.String(), args
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// quoteIdent quotes a possibly schema qualified SQL Server identifier.
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = "[" + strings.ReplaceAll(p, "]", "]]") + "]"
+	}
+	return strings.Join(parts, ".")
+}
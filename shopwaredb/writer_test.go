@@ -0,0 +1,65 @@
+package shopwaredb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/config"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+func TestTableMerge(t *testing.T) {
+	tbl := newTable("dbo.Spec]tro", &config.ShopwareColumns{
+		TimeStamp:  "MeasuredAt",
+		SampleName: "Sample",
+		Furnace:    "Furnace",
+		Spectro:    "Machine",
+		Elements:   map[string]string{"Si": "Silicon", "C": "Carbon", "Mn": "Manganese"},
+	})
+
+	s := &sample.Record{
+		SampleName: "O'Brien 1",
+		Furnace:    "F1",
+		TimeStamp:  time.Date(2023, 5, 1, 8, 30, 15, 0, time.Local),
+		ResultsMap: map[string]float64{"C": 3.41, "Si": 2.1, "Cu": 0.5},
+	}
+
+	q, args := tbl.merge(2, s)
+	wantQ := "MERGE INTO [dbo].[Spec]]tro] WITH (HOLDLOCK) AS t" +
+		" USING (VALUES (CONVERT(DATETIME2(0), @p1, 126), @p2, @p3, @p4, @p5, @p6))" +
+		" AS s ([MeasuredAt], [Sample], [Furnace], [Machine], [Carbon], [Silicon])" +
+		" ON t.[Machine] = s.[Machine] AND t.[Sample] = s.[Sample] AND t.[MeasuredAt] = s.[MeasuredAt]" +
+		" WHEN MATCHED THEN UPDATE SET [Furnace] = s.[Furnace], [Carbon] = s.[Carbon], [Silicon] = s.[Silicon]" +
+		" WHEN NOT MATCHED THEN INSERT ([MeasuredAt], [Sample], [Furnace], [Machine], [Carbon], [Silicon])" +
+		" VALUES (s.[MeasuredAt], s.[Sample], s.[Furnace], s.[Machine], s.[Carbon], s.[Silicon]) OUTPUT $action;"
+	if q != wantQ {
+		t.Errorf("query:\n got %s\nwant %s", q, wantQ)
+	}
+	wantArgs := []interface{}{"2023-05-01T08:30:15", "O'Brien 1", "F1", 2, 3.41, 2.1}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args: got %v, want %v", args, wantArgs)
+	}
+
+	// fields parsed from sample names, NULL if not in the name
+	tbl = newTable("Spectro", &config.ShopwareColumns{
+		TimeStamp: "DateTimeStamp", SampleName: "SampleName", Furnace: "Furname", Spectro: "Spectro",
+		Heat: "Heat", Type: "SampleType",
+	})
+	s.Heat = "1234"
+	q, args = tbl.merge(2, s)
+	wantQ = "MERGE INTO [Spectro] WITH (HOLDLOCK) AS t" +
+		" USING (VALUES (CONVERT(DATETIME2(0), @p1, 126), @p2, @p3, @p4, @p5, @p6))" +
+		" AS s ([DateTimeStamp], [SampleName], [Furname], [Spectro], [Heat], [SampleType])" +
+		" ON t.[Spectro] = s.[Spectro] AND t.[SampleName] = s.[SampleName] AND t.[DateTimeStamp] = s.[DateTimeStamp]" +
+		" WHEN MATCHED THEN UPDATE SET [Furname] = s.[Furname], [Heat] = s.[Heat], [SampleType] = s.[SampleType]" +
+		" WHEN NOT MATCHED THEN INSERT ([DateTimeStamp], [SampleName], [Furname], [Spectro], [Heat], [SampleType])" +
+		" VALUES (s.[DateTimeStamp], s.[SampleName], s.[Furname], s.[Spectro], s.[Heat], s.[SampleType]) OUTPUT $action;"
+	if q != wantQ {
+		t.Errorf("query with fields:\n got %s\nwant %s", q, wantQ)
+	}
+	wantArgs = []interface{}{"2023-05-01T08:30:15", "O'Brien 1", "F1", 2, "1234", nil}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args with fields: got %v, want %v", args, wantArgs)
+	}
+}
@@ -2,12 +2,15 @@ package SpectroDashboard_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/RoanBrand/SpectroDashboard/config"
 	ht "github.com/RoanBrand/SpectroDashboard/http"
 	"github.com/RoanBrand/SpectroDashboard/log"
 	"github.com/RoanBrand/SpectroDashboard/mdb_spectro"
 	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/xml_spectro/fileparser"
+	"io/fs"
 	"math/rand"
 	"net/http"
 	"path/filepath"
@@ -28,23 +31,39 @@ func TestResultsRetrieval(t *testing.T) {
 	errPipe := make(chan string)
 
 	conf, err := config.LoadConfig(filepath.Join(homePath, "config.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skip("no spectro setup at", homePath)
+	}
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ht.SetupServer(filepath.Join(homePath, "static"))
+	sources := make([]sample.ResultSource, 0, len(conf.Sources))
+	for _, sc := range conf.Sources {
+		switch sc.Type {
+		case config.SourceMDB:
+			sources = append(sources, mdb_spectro.NewSource(sc.DataSource, sc.SpectroNumber, nil))
+		case config.SourceXML:
+			sources = append(sources, fileparser.NewSource(sc.DataSource, sc.SpectroNumber, nil))
+		default:
+			sources = append(sources, ht.NewRemoteSource(sc.DataSource, sc.SpectroNumber, sc.TimeoutDuration(), nil))
+		}
+	}
+
+	ht.SetupServer(filepath.Join(homePath, "static"), func(string, bool) ([]byte, error) {
+		results, err := getResults(conf, sources)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(results)
+	}, nil, nil)
 
 	go func() {
-		if err := ht.StartServer(conf.HTTPServerPort, func() (interface{}, error) {
-			results, err := getResults(conf)
-			if err != nil {
-				return nil, err
-			}
-			return results, nil
-		}); err != nil {
+		if err := ht.StartServer(conf.HTTPServerPort); err != nil {
 			errPipe <- err.Error()
 		}
 	}()
+	defer ht.StopServer()
 
 	time.Sleep(time.Millisecond * 10)
 	var wg sync.WaitGroup
@@ -56,23 +75,20 @@ func TestResultsRetrieval(t *testing.T) {
 			time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
 
 			c := http.Client{}
-			resp, err := c.Get("http://localhost:80/results")
+			resp, err := c.Get("http://localhost:" + conf.HTTPServerPort + "/results")
 			if err != nil {
-				fmt.Println(i, "error-", err)
 				errPipe <- fmt.Sprintf("Error retrieving results on iteration %d: %s", i, err)
-				fmt.Println(i, "error2-", err)
 				return
 			}
 			defer resp.Body.Close()
 			if resp.StatusCode != http.StatusOK {
-				errPipe <- fmt.Sprintf("Error retrieving results on iteration %d: %s", i, err)
+				errPipe <- fmt.Sprintf("Error retrieving results on iteration %d: %s", i, resp.Status)
 				return
 			}
 
 			var res []sample.Record
 			dec := json.NewDecoder(resp.Body)
-			err = dec.Decode(&res)
-			if resp.StatusCode != http.StatusOK {
+			if err = dec.Decode(&res); err != nil {
 				errPipe <- fmt.Sprintf("Error decoding results on iteration %d: %s", i, err)
 				return
 			}
@@ -98,13 +114,13 @@ func TestResultsRetrieval(t *testing.T) {
 // result cache
 var lock sync.RWMutex
 var age time.Time
-var cacheResult []sample.Record
+var cacheResult []*sample.Record
 
-func getResults(conf *config.Config) ([]sample.Record, error) {
+func getResults(conf *config.Config, sources []sample.ResultSource) ([]*sample.Record, error) {
 	// check if we have a recent enough result in cache
 	lock.RLock()
 	if time.Now().Sub(age) < time.Second*5 {
-		finalRes := make([]sample.Record, len(cacheResult))
+		finalRes := make([]*sample.Record, len(cacheResult))
 		copy(finalRes, cacheResult)
 		lock.RUnlock()
 		return finalRes, nil
@@ -117,54 +133,22 @@ func getResults(conf *config.Config) ([]sample.Record, error) {
 
 	// need to check if result still old, otherwise return new result
 	if time.Now().Sub(age) < time.Second*5 {
-		finalRes := make([]sample.Record, len(cacheResult))
+		finalRes := make([]*sample.Record, len(cacheResult))
 		copy(finalRes, cacheResult)
 		return finalRes, nil
 	}
 
 	cacheResult = cacheResult[:0]
-	var remoteRes []sample.Record
-	remoteDone := make(chan struct{})
-
-	if conf.RemoteMachineAddress != "" {
-		errOccurred := func(err ...interface{}) {
-			log.Println("Error retrieving remote results from", conf.RemoteMachineAddress, ":", err)
+	for _, s := range sources {
+		res, err := s.LatestResults(conf.NumberOfResults)
+		if err != nil {
+			log.Println("Error retrieving results of spectro", s.Spectro(), ":", err)
+			continue
 		}
-		go func() {
-			defer func() { remoteDone <- struct{}{} }()
-
-			resp, err := ht.GetRemoteResults(conf.RemoteMachineAddress)
-			if err != nil {
-				errOccurred(err)
-				return
-			}
-			if resp.StatusCode != 200 {
-				errOccurred(resp.StatusCode, " ", resp.Status)
-			}
-			defer resp.Body.Close()
-
-			dec := json.NewDecoder(resp.Body)
-			err = dec.Decode(&remoteRes)
-			if err != nil {
-				errOccurred(err)
-				return
-			}
-		}()
-	}
-
-	res, err := mdb_spectro.GetResults(conf.DataSource, conf.NumberOfResults, conf.ElementOrder)
-	if err == nil {
-		cacheResult = append(cacheResult, res...)
 		if len(res) == 0 {
-			log.Println("0 results found in", conf.DataSource)
+			log.Println("0 results found of spectro", s.Spectro())
 		}
-	} else {
-		log.Println("Error retrieving local results from", conf.DataSource, ":", err)
-	}
-
-	if conf.RemoteMachineAddress != "" {
-		<-remoteDone
-		cacheResult = append(cacheResult, remoteRes...)
+		cacheResult = append(cacheResult, res...)
 	}
 
 	sort.Slice(cacheResult, func(i, j int) bool {
@@ -176,7 +160,7 @@ func getResults(conf *config.Config) ([]sample.Record, error) {
 		cacheResult = cacheResult[:conf.NumberOfResults]
 	}
 
-	finalRes := make([]sample.Record, len(cacheResult))
+	finalRes := make([]*sample.Record, len(cacheResult))
 	copy(finalRes, cacheResult)
 	age = time.Now()
 
@@ -0,0 +1,362 @@
+package fileparser
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/log"
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/samplename"
+)
+
+// period between scans of the results folder for new and changed files.
+var pollInterval = time.Second * 3
+
+// Index is an in-memory catalogue of the samples in a folder of spectro result xml files.
+// Each file is only parsed once, or again if its modification time or size changes.
+type Index struct {
+	xmlFolder string
+	spectro   int
+	names     *samplename.Parser // of parsed samples, if set
+
+	scanMu sync.Mutex // one scan at a time
+
+	mu         sync.RWMutex
+	scanned    bool
+	files      map[string]*indexedFile // by file name
+	samples    []*sample.Record        // ordered by time, oldest first
+	onChange   []func(added []*sample.Record)
+	unreported []*sample.Record // copies of samples added since last reported to onChange
+	watchErr   error            // of the last scan by Watch, reported by Check
+
+	reportMu sync.Mutex // reports in order
+}
+
+type indexedFile struct {
+	modTime time.Time
+	size    int64
+	rec     *sample.Record // nil if file has no valid sample
+}
+
+func NewIndex(xmlFolder string, spectro int) *Index {
+	return &Index{
+		xmlFolder: xmlFolder,
+		spectro:   spectro,
+		files:     make(map[string]*indexedFile),
+	}
+}
+
+// Watch scans the results folder until ctx is done. Failed scans are logged when they start failing,
+// and reported by Check.
+func (ix *Index) Watch(ctx context.Context) {
+	t := time.NewTimer(pollInterval)
+
+	for {
+		select {
+		case <-t.C:
+			err := ix.Refresh()
+
+			ix.mu.Lock()
+			prevErr := ix.watchErr
+			ix.watchErr = err
+			ix.mu.Unlock()
+
+			if err != nil && (prevErr == nil || prevErr.Error() != err.Error()) {
+				log.Println("failed to scan", ix.xmlFolder, "for results:", err)
+			} else if err == nil && prevErr != nil {
+				log.Println("scanning", ix.xmlFolder, "for results again")
+			}
+
+			t.Reset(pollInterval)
+
+		case <-ctx.Done():
+			if !t.Stop() {
+				<-t.C
+			}
+			return
+		}
+	}
+}
+
+// Refresh scans the results folder once, parsing new and changed files
+// and dropping removed ones from the catalogue. Samples added are reported
+// to OnNewSamples callbacks, in the calling goroutine.
+func (ix *Index) Refresh() error {
+	err := ix.scan()
+	ix.report()
+	return err
+}
+
+// scan the results folder once, keeping samples added for the next report.
+func (ix *Index) scan() error {
+	ix.scanMu.Lock()
+
+	entries, err := os.ReadDir(ix.xmlFolder)
+	if err != nil {
+		ix.scanMu.Unlock()
+		return err
+	}
+
+	// only scans modify files, so no need for lock while reading it here.
+	known := ix.files
+	changed := make(map[string]*indexedFile)
+	present := make(map[string]struct{}, len(entries))
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".xml" || !strings.Contains(name, "spectro") {
+			continue
+		}
+		present[name] = struct{}{}
+
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since listing
+		}
+
+		if f, ok := known[name]; ok && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+			continue
+		}
+
+		// Files still being written fail to parse, and are parsed again once they change.
+		f := &indexedFile{modTime: info.ModTime(), size: info.Size()}
+		file := filepath.Join(ix.xmlFolder, name)
+		if srfile, err := decodeFile(file); err == nil {
+			if f.rec = parseRecord(file, srfile, ix.spectro); f.rec != nil {
+				ix.names.Parse(f.rec)
+			}
+		}
+		changed[name] = f
+	}
+
+	ix.mu.Lock()
+
+	removed := false
+	for name := range ix.files {
+		if _, ok := present[name]; !ok {
+			delete(ix.files, name)
+			removed = true
+		}
+	}
+
+	added := make([]*sample.Record, 0, len(changed))
+	for name, f := range changed {
+		ix.files[name] = f
+		if f.rec != nil {
+			added = append(added, copyRecord(f.rec))
+		}
+	}
+
+	if removed || len(changed) > 0 {
+		ix.rebuild()
+	}
+
+	if ix.scanned {
+		ix.unreported = append(ix.unreported, added...)
+	}
+	ix.scanned = true
+	ix.mu.Unlock()
+	ix.scanMu.Unlock()
+
+	return nil
+}
+
+// report samples added since last reported to OnNewSamples callbacks.
+func (ix *Index) report() {
+	ix.reportMu.Lock()
+	defer ix.reportMu.Unlock()
+
+	ix.mu.Lock()
+	added, onChange := ix.unreported, ix.onChange
+	ix.unreported = nil
+	ix.mu.Unlock()
+
+	if len(added) > 0 {
+		sortRecords(added)
+		for _, fn := range onChange {
+			fn(added)
+		}
+	}
+}
+
+// Check that the results folder can be read, and that the last scan by Watch succeeded.
+func (ix *Index) Check() error {
+	f, err := os.Open(ix.xmlFolder)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if ix.watchErr != nil {
+		return fmt.Errorf("failed to scan results folder: %w", ix.watchErr)
+	}
+	return nil
+}
+
+// OnNewSamples registers fn to be called with copies of samples that are added to the
+// catalogue after the initial scan, oldest first. It is called by Refresh, and Watch,
+// but not by reads that scan the folder for the first time.
+func (ix *Index) OnNewSamples(fn func(added []*sample.Record)) {
+	ix.mu.Lock()
+	ix.onChange = append(ix.onChange, fn)
+	ix.mu.Unlock()
+}
+
+// rebuild time ordered catalogue from files. must hold write lock.
+func (ix *Index) rebuild() {
+	samples := make([]*sample.Record, 0, len(ix.files))
+	for _, f := range ix.files {
+		if f.rec != nil {
+			samples = append(samples, f.rec)
+		}
+	}
+
+	sortRecords(samples)
+	ix.samples = samples
+}
+
+func sortRecords(recs []*sample.Record) {
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].TimeStamp.Equal(recs[j].TimeStamp) {
+			return recs[i].ID < recs[j].ID
+		}
+		return recs[i].TimeStamp.Before(recs[j].TimeStamp)
+	})
+}
+
+// ensureScanned scans the folder if it has never been scanned before.
+// Callers may hold locks of their own, so new samples are reported by the next Refresh.
+func (ix *Index) ensureScanned() error {
+	ix.mu.RLock()
+	scanned := ix.scanned
+	ix.mu.RUnlock()
+
+	if scanned {
+		return nil
+	}
+	return ix.scan()
+}
+
+// Latest returns copies of the latest n samples, latest first.
+func (ix *Index) Latest(n int) ([]*sample.Record, error) {
+	if err := ix.ensureScanned(); err != nil {
+		return nil, err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if n > len(ix.samples) {
+		n = len(ix.samples)
+	}
+
+	recs := make([]*sample.Record, n)
+	for i := range recs {
+		recs[i] = copyRecord(ix.samples[len(ix.samples)-1-i])
+	}
+
+	return recs, nil
+}
+
+// Between returns copies of samples measured from, up to but excluding, to, in time order.
+// Zero to is unbounded.
+func (ix *Index) Between(from, to time.Time) ([]*sample.Record, error) {
+	if err := ix.ensureScanned(); err != nil {
+		return nil, err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	i := sort.Search(len(ix.samples), func(i int) bool {
+		return !ix.samples[i].TimeStamp.Before(from)
+	})
+
+	var recs []*sample.Record
+	for ; i < len(ix.samples); i++ {
+		if !to.IsZero() && !ix.samples[i].TimeStamp.Before(to) {
+			break
+		}
+		recs = append(recs, copyRecord(ix.samples[i]))
+	}
+
+	return recs, nil
+}
+
+// LastOfFurnaces returns copies of the latest sample of each furnace, in the order of furnaces given.
+// Only samples that match are considered. Nil match matches all.
+func (ix *Index) LastOfFurnaces(furnaces []string, match func(r *sample.Record) bool) ([]*sample.Record, error) {
+	if err := ix.ensureScanned(); err != nil {
+		return nil, err
+	}
+
+	needed := make(map[string]*sample.Record, len(furnaces))
+	for _, f := range furnaces {
+		needed[strings.ToUpper(f)] = nil
+	}
+
+	ix.mu.RLock()
+	found := 0
+	for i := len(ix.samples) - 1; i >= 0 && found < len(needed); i-- {
+		r := ix.samples[i]
+		F := strings.ToUpper(r.Furnace)
+		if last, ok := needed[F]; ok && last == nil && (match == nil || match(r)) {
+			needed[F] = r
+			found++
+		}
+	}
+	ix.mu.RUnlock()
+
+	records := make([]*sample.Record, 0, found)
+	for _, f := range furnaces {
+		F := strings.ToUpper(f)
+		if r := needed[F]; r != nil {
+			rec := copyRecord(r)
+			rec.Furnace = F
+			records = append(records, rec)
+			needed[F] = nil
+		}
+	}
+
+	return records, nil
+}
+
+// ByID returns a copy of the sample with the given ID.
+func (ix *Index) ByID(id string) (*sample.Record, error) {
+	if err := ix.ensureScanned(); err != nil {
+		return nil, err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	f, ok := ix.files[id+".xml"]
+	if !ok || f.rec == nil {
+		return nil, sample.ErrNotFound
+	}
+
+	return copyRecord(f.rec), nil
+}
+
+// catalogued records are shared, so callers get their own copy.
+func copyRecord(r *sample.Record) *sample.Record {
+	c := *r
+	c.ResultsMap = make(map[string]float64, len(r.ResultsMap))
+	for el, v := range r.ResultsMap {
+		c.ResultsMap[el] = v
+	}
+	return &c
+}
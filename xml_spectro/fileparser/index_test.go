@@ -0,0 +1,186 @@
+package fileparser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+)
+
+const testXML = `<SampleResults>
+<SampleResult RecalculationDateTime="%s" MethodName="Fe-10">
+	<SampleIDs>
+		<SampleID><IDName>Sample ID</IDName><IDValue>%s</IDValue></SampleID>
+		<SampleID><IDName>Quality</IDName><IDValue>%s</IDValue></SampleID>
+	</SampleIDs>
+	<MeasurementStatistics><Measurement><Elements>
+		<Element ElementName="C"><ElementResult StatType="Reported"><ResultValue>%g</ResultValue></ElementResult></Element>
+	</Elements></Measurement></MeasurementStatistics>
+</SampleResult>
+</SampleResults>`
+
+func writeTestSample(t *testing.T, dir, file, ts, name, furnace string, c float64) {
+	t.Helper()
+	content := []byte(fmt.Sprintf(testXML, ts, name, furnace, c))
+	if err := os.WriteFile(filepath.Join(dir, file), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSample(t, dir, "spectro_1.xml", "2023-05-01T10:00:00", "A1", "f1", 3.1)
+	writeTestSample(t, dir, "spectro_2.xml", "2023-05-01T11:00:00", "B1", "F2", 3.2)
+	writeTestSample(t, dir, "spectro_3.xml", "2023-05-01T12:00:00", "A2", "F1", 3.3)
+	writeTestSample(t, dir, "other.xml", "2023-05-01T13:00:00", "X", "F1", 1)
+	if err := os.WriteFile(filepath.Join(dir, "spectro_4.xml"), []byte("<SampleRes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix := NewIndex(dir, 3)
+	var added []*sample.Record
+	ix.OnNewSamples(func(recs []*sample.Record) { added = append(added, recs...) })
+
+	latest, err := ix.Latest(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 3 || latest[0].SampleName != "A2" || latest[2].SampleName != "A1" {
+		t.Fatalf("unexpected latest samples: %+v", latest)
+	}
+	if latest[0].ResultsMap["C"] != 3.3 || latest[0].Spectro != 3 || latest[0].ID != "spectro_3" {
+		t.Fatalf("unexpected sample: %+v", latest[0])
+	}
+	if len(added) != 0 {
+		t.Fatal("initial scan should not report new samples")
+	}
+
+	last, err := ix.LastOfFurnaces([]string{"F2", "f1", "F9"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || last[0].SampleName != "B1" || last[1].SampleName != "A2" {
+		t.Fatalf("unexpected last furnace samples: %+v", last)
+	}
+	notA2 := func(r *sample.Record) bool { return r.SampleName != "A2" }
+	if last, _ = ix.LastOfFurnaces([]string{"F1"}, notA2); len(last) != 1 || last[0].SampleName != "A1" {
+		t.Fatalf("unexpected last matching furnace samples: %+v", last)
+	}
+
+	between, err := ix.Between(latest[1].TimeStamp, latest[0].TimeStamp)
+	if err != nil || len(between) != 1 || between[0].SampleName != "B1" {
+		t.Fatalf("unexpected samples between: %+v %v", between, err)
+	}
+	if between, _ = ix.Between(latest[1].TimeStamp, time.Time{}); len(between) != 2 || between[1].SampleName != "A2" {
+		t.Fatalf("unexpected samples since: %+v", between)
+	}
+
+	// partially written file is completed, and a sample is removed
+	writeTestSample(t, dir, "spectro_4.xml", "2023-05-01T09:00:00", "C1", "F3", 3.4)
+	os.Chtimes(filepath.Join(dir, "spectro_4.xml"), time.Now(), time.Now().Add(time.Second))
+	if err = os.Remove(filepath.Join(dir, "spectro_2.xml")); err != nil {
+		t.Fatal(err)
+	}
+	if err = ix.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(added) != 1 || added[0].SampleName != "C1" {
+		t.Fatalf("unexpected new samples: %+v", added)
+	}
+	// callbacks get their own copy
+	added[0].SampleName, added[0].ResultsMap["C"] = "changed", 0
+
+	latest, _ = ix.Latest(10)
+	if len(latest) != 3 || latest[2].SampleName != "C1" || latest[2].ResultsMap["C"] != 3.4 {
+		t.Fatalf("unexpected latest samples after refresh: %+v", latest)
+	}
+
+	if _, err = ix.ByID("spectro_2"); err != sample.ErrNotFound {
+		t.Fatalf("expected removed sample to be gone, got %v", err)
+	}
+	r, err := ix.ByID("spectro_4")
+	if err != nil || r.SampleName != "C1" {
+		t.Fatalf("unexpected sample by id: %+v %v", r, err)
+	}
+}
+
+func TestIndexReportsOnRefresh(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSample(t, dir, "spectro_1.xml", "2023-05-01T10:00:00", "A1", "F1", 3.1)
+
+	ix := NewIndex(dir, 3)
+	var added []*sample.Record
+	ix.OnNewSamples(func(recs []*sample.Record) { added = append(added, recs...) })
+	if err := ix.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a scan by a reader, that may hold locks of its own, does not call back
+	writeTestSample(t, dir, "spectro_2.xml", "2023-05-01T11:00:00", "A2", "F1", 3.2)
+	if err := ix.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 0 {
+		t.Fatalf("unexpected new samples reported by scan: %+v", added)
+	}
+
+	if err := ix.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].SampleName != "A2" {
+		t.Fatalf("unexpected new samples: %+v", added)
+	}
+}
+
+func TestIndexWatchErrors(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond * 10
+
+	dir := filepath.Join(t.TempDir(), "results")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ix := NewIndex(dir, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ix.Watch(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	watchFailing := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+			ix.mu.RLock()
+			failing := ix.watchErr != nil
+			ix.mu.RUnlock()
+			if failing == want {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting for watch failing to be %v", want)
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	watchFailing(true)
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	watchFailing(false)
+	if err := ix.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
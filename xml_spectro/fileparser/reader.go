@@ -4,9 +4,11 @@ import (
 	"encoding/xml"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
+
+	"github.com/RoanBrand/SpectroDashboard/sample"
+	"github.com/RoanBrand/SpectroDashboard/samplename"
 )
 
 var elements = map[string]struct{}{
@@ -37,153 +39,105 @@ var elements = map[string]struct{}{
 	"Fe": {},
 }
 
-type Record struct {
-	ID        string             `json:"id"`
-	Furnace   string             `json:"furnace"`
-	TimeStamp time.Time          `json:"time_stamp"`
-	Results   map[string]float64 `json:"results"`
+// Source reads sample results from a folder of spectro result xml files.
+// The ID of a sample is its file name, without extension.
+type Source struct {
+	*Index
 }
 
-func GetLastFurnaceResults(xmlFolder string, furnaces []string) ([]*Record, error) {
-	files, err := filepath.Glob(filepath.Join(xmlFolder, "*"))
-	if err != nil {
-		return nil, err
-	}
+// NewSource parses sample names with names, if not nil.
+func NewSource(xmlFolder string, spectro int, names *samplename.Parser) *Source {
+	ix := NewIndex(xmlFolder, spectro)
+	ix.names = names
+	return &Source{Index: ix}
+}
 
-	// sort files (spectro XML file names contain dates, so we assume results will be sorted)
-	sort.Slice(files, func(i, j int) bool {
-		return files[i] > files[j]
-	})
+func (s *Source) Spectro() int {
+	return s.spectro
+}
 
-	// filter out any non spectro result xml files.
-	xmlFiles := files[:0]
-	for _, file := range files {
-		if filepath.Ext(file) == ".xml" && strings.Contains(file, "spectro") {
-			xmlFiles = append(xmlFiles, file)
-		}
-	}
+func (s *Source) LastFurnaceResults(furnaces []string, sampleType string) ([]*sample.Record, error) {
+	return s.LastOfFurnaces(furnaces, s.names.Match(sampleType))
+}
+
+// get test samples from xml files, ordered descending, i.e. latest first
+func (s *Source) LatestResults(numResults int) ([]*sample.Record, error) {
+	return s.Latest(numResults)
+}
+
+func (s *Source) ResultsBetween(from, to time.Time) ([]*sample.Record, error) {
+	return s.Between(from, to)
+}
 
-	furnesLookup := make(map[string]*Record, len(furnaces))
-	neededLookup := make(map[string]struct{}, len(furnaces))
-	for _, f := range furnaces {
-		furnesLookup[strings.ToUpper(f)] = &Record{}
-		neededLookup[strings.ToUpper(f)] = struct{}{}
+func (s *Source) ResultByID(id string) (*sample.Record, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, sample.ErrNotFound
 	}
 
-	var srfile sampleResultsXMLFile
+	return s.ByID(id)
+}
 
-	for _, xmlFile := range xmlFiles {
-		if len(neededLookup) == 0 {
-			break
-		}
+// parseRecord returns the sample in a result file, or nil if it has no valid sample.
+func parseRecord(file string, srfile *sampleResultsXMLFile, spectro int) *sample.Record {
+	var rec *sample.Record
 
-		f, err := os.Open(xmlFile)
+	for j := range srfile.SampleResults { // is actually one sample per file
+		sr := &srfile.SampleResults[j]
+
+		ts, err := time.ParseInLocation("2006-01-02T15:04:05", sr.Timestamp, time.Local)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		dec := xml.NewDecoder(f)
-		err = dec.Decode(&srfile)
-		if err != nil {
-			return nil, err
+		rec = &sample.Record{
+			ID:         fileID(file),
+			SampleName: sr.SampleID(),
+			Furnace:    sr.Furnace(),
+			TimeStamp:  ts,
+			Method:     sr.Method,
+			Operator:   sr.Operator(),
+			Spectro:    spectro,
+			ResultsMap: make(map[string]float64, len(elements)),
 		}
 
-		for _, sres := range srfile.SampleResults {
-			F := strings.ToUpper(sres.Furnace())
-			r, ok := furnesLookup[F]
-			if !ok {
-				continue
-			}
+		if len(sr.MeasurementStatistics) == 0 {
+			continue
+		}
 
-			ts, err := time.ParseInLocation("2006-01-02T15:04:05", sres.Timestamp, time.Local)
-			if err != nil {
+		for _, el := range sr.MeasurementStatistics[0].Elements {
+			res := el.reportedResult()
+			if res == nil {
 				continue
 			}
 
-			if ts.Before(r.TimeStamp) {
-				continue
+			// lookup element. if not present it is not one we want
+			if _, present := elements[el.Name]; present {
+				rec.ResultsMap[el.Name] = res.ResultValue
+				if len(rec.ResultsMap) == len(elements) {
+					break
+				}
 			}
-
-			r.ID = sres.SampleID()
-			r.Furnace = F
-			r.TimeStamp = ts
-
-			delete(neededLookup, F)
 		}
 	}
 
-	records := make([]*Record, 0, len(furnaces))
-	for _, fn := range furnaces {
-		r := furnesLookup[strings.ToUpper(fn)]
-		if r.ID == "" {
-			continue
-		}
-
-		records = append(records, r)
-	}
-
-	return records, nil
+	return rec
 }
 
-// get test samples from xml files, ordered descending, i.e. latest first
-func GetResults(xmlFolder string, numResults int) ([]Record, error) {
-	// Glob sorts filenames in increasing order, and spectro file names contain dates,
-	// so we assume results will be sorted ascending.
-	files, err := filepath.Glob(filepath.Join(xmlFolder, "*spectro*.xml"))
+func decodeFile(file string) (*sampleResultsXMLFile, error) {
+	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	if len(files) < numResults {
-		numResults = len(files) // hard limit to numResults
-	}
-
-	results := make([]sampleResultsXMLFile, numResults)
-
-	for i := 0; i < numResults; i++ {
-		// open files in reverse order to get data in desc order (latest first)
-		f, err := os.Open(files[len(files)-1-i])
-		if err != nil {
-			return nil, err
-		}
-
-		if err = xml.NewDecoder(f).Decode(&results[i]); err != nil {
-			return nil, err
-		}
+	srfile := new(sampleResultsXMLFile)
+	if err = xml.NewDecoder(f).Decode(srfile); err != nil {
+		return nil, err
 	}
 
-	recs := make([]Record, numResults)
-
-	for i := range results {
-		rec := &recs[i]
-
-		for j := range results[i].SampleResults { // is actually one sample per file
-			sr := &results[i].SampleResults[j]
-
-			rec.ID = sr.SampleID()
-			rec.Furnace = sr.Furnace()
-			rec.TimeStamp, err = time.ParseInLocation("2006-01-02T15:04:05", sr.Timestamp, time.Local)
-			if err != nil {
-				continue
-			}
-
-			rec.Results = make(map[string]float64, len(elements))
-			for _, el := range sr.MeasurementStatistics[0].Elements {
-				res := el.reportedResult()
-				if res == nil {
-					continue
-				}
-
-				// lookup element. if not present it is not one we want
-				if _, present := elements[el.Name]; present {
-					rec.Results[el.Name] = res.ResultValue
-					if len(rec.Results) == len(elements) {
-						break
-					}
-				}
-			}
-		}
-	}
+	return srfile, nil
+}
 
-	return recs, nil
+func fileID(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
 }